@@ -0,0 +1,57 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"math/rand"
+	"time"
+)
+
+// Writes lastAccessedAt of the read documents if the collection is configured
+// in AccessTracking. Only the given ratio of the reads are recorded and the
+// write happens in the background, so reads don't wait for it.
+func (ma DataProvider) trackAccess(collection string, items []map[string]interface{}) {
+
+	sampleRate, isTracked := ma.AccessTracking[collection]
+	if !isTracked || len(items) == 0 || ma.session == nil || ma.IsReadOnly() {
+		return
+	}
+	if sampleRate < 1 && rand.Float64() >= sampleRate {
+		return
+	}
+
+	ids := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if id, hasId := item[ID]; hasId {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	// the session is copied before returning so Close can't release it in between
+	sessionCopy := ma.session.Copy()
+	if ma.state != nil {
+		ma.state.inFlight.Add(1)
+	}
+
+	go func() {
+		defer sessionCopy.Close()
+		if ma.state != nil {
+			defer ma.state.inFlight.Done()
+		}
+		sessionCopy.SetSyncTimeout(1 * time.Second)
+		sessionCopy.SetSocketTimeout(1 * time.Second)
+
+		_, updateErr := sessionCopy.DB(ma.Database).C(collection).UpdateAll(
+			bson.M{ID: bson.M{"$in": ids}},
+			bson.M{"$set": bson.M{LastAccessedAt: ma.now()}},
+		)
+		if updateErr != nil {
+			ma.logger().WithFields(LogFields{
+				"reason":     updateErr.Error(),
+				"collection": collection,
+			}).Error("Mongo Error: Updating last access time failed.")
+		}
+	}()
+}
@@ -0,0 +1,348 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"time"
+)
+
+// Returns the document count, storage size, index sizes and average object
+// size of the collection, as reported by the collStats command. Sizes are in bytes.
+func (ma DataProvider) CollStats(collection string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("collStats", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	var stats bson.M
+	statsErr := ma.retry(sessionCopy, 5, func() (err error) {
+		return sessionCopy.DB(ma.Database).Run(bson.D{{Name: "collStats", Value: collection}}, &stats)
+	})
+	if statsErr != nil {
+		err = driverError(statsErr, "Getting statistics of '"+collection+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     statsErr.Error(),
+			"collection": collection,
+		}).Error("Mongo Error: Getting collection statistics failed.")
+		return
+	}
+
+	response = map[string]interface{}{
+		"count":          stats["count"],
+		"size":           stats["size"],
+		"storageSize":    stats["storageSize"],
+		"totalIndexSize": stats["totalIndexSize"],
+		"indexSizes":     stats["indexSizes"],
+		"avgObjSize":     stats["avgObjSize"],
+		"capped":         stats["capped"],
+	}
+	return
+}
+
+// Returns the storage usage of the database, as reported by the dbStats command.
+// Sizes are in bytes.
+func (ma DataProvider) DBStats() (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("dbStats", "")
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	var stats bson.M
+	statsErr := ma.retry(sessionCopy, 5, func() (err error) {
+		return sessionCopy.DB(ma.Database).Run(bson.D{{Name: "dbStats", Value: 1}}, &stats)
+	})
+	if statsErr != nil {
+		err = driverError(statsErr, "Getting database statistics failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":   statsErr.Error(),
+			"database": ma.Database,
+		}).Error("Mongo Error: Getting database statistics failed.")
+		return
+	}
+
+	response = map[string]interface{}{
+		"database":    ma.Database,
+		"collections": stats["collections"],
+		"views":       stats["views"],
+		"objects":     stats["objects"],
+		"dataSize":    stats["dataSize"],
+		"storageSize": stats["storageSize"],
+		"indexes":     stats["indexes"],
+		"indexSize":   stats["indexSize"],
+		"avgObjSize":  stats["avgObjSize"],
+	}
+	return
+}
+
+// Returns the names of the collections in the database under "results".
+func (ma DataProvider) ListCollections() (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("listCollections", "")
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	var names []string
+	listErr := ma.retry(sessionCopy, 5, func() (err error) {
+		names, err = sessionCopy.DB(ma.Database).CollectionNames()
+		return
+	})
+	if listErr != nil {
+		err = driverError(listErr, "Listing collections failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":   listErr.Error(),
+			"database": ma.Database,
+		}).Error("Mongo Error: Listing collections failed.")
+		return
+	}
+
+	if names == nil {
+		names = make([]string, 0)
+	}
+	response = map[string]interface{}{
+		List: names,
+	}
+	return
+}
+
+// Options of CreateCollection. Capped collections keep only the last Size
+// bytes or the last Max documents, in insertion order.
+type CollectionOptions struct {
+	Capped bool
+	// maximum size in bytes, required for capped collections
+	Size int
+	// maximum number of documents of a capped collection
+	Max int
+}
+
+// Creates the collection explicitly, which is only needed for
+// collections with options like capped collections.
+// Example Usage:
+// err := provider.CreateCollection("logs", mongoutil.CollectionOptions{Capped: true, Size: 1 << 20})
+func (ma DataProvider) CreateCollection(name string, options CollectionOptions) (err *utils.Error) {
+
+	op, err := ma.begin("createCollection", name)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if options.Capped && options.Size <= 0 {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Capped collections must have a size.",
+		}
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	createErr := sessionCopy.DB(ma.Database).C(name).Create(&mgo.CollectionInfo{
+		Capped:   options.Capped,
+		MaxBytes: options.Size,
+		MaxDocs:  options.Max,
+	})
+	if createErr != nil {
+		if isNamespaceExists(createErr) {
+			err = newError(ErrDuplicate, "Collection '"+name+"' already exists.")
+		} else {
+			err = driverError(createErr, "Creating collection '"+name+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     createErr.Error(),
+			"collection": name,
+		}).Error("Mongo Error: Creating collection failed.")
+	}
+	return
+}
+
+func isNamespaceExists(err error) bool {
+	queryErr, isQueryErr := err.(*mgo.QueryError)
+	return isQueryErr && queryErr.Code == 48
+}
+
+// Creates a read-only view of the source collection defined by the pipeline.
+// Views are queried with Query and Get like collections, writes to them fail with 405.
+// Example Usage:
+// err := provider.CreateView("activeUsers", "users", mongoutil.NewPipeline().Match(map[string]interface{}{"active": true}).Build())
+func (ma DataProvider) CreateView(name, source string, pipeline []interface{}) (err *utils.Error) {
+
+	op, err := ma.begin("createView", name)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if pipeline == nil {
+		pipeline = make([]interface{}, 0)
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	createErr := sessionCopy.DB(ma.Database).Run(bson.D{
+		{Name: "create", Value: name},
+		{Name: "viewOn", Value: source},
+		{Name: "pipeline", Value: pipeline},
+	}, nil)
+	if createErr != nil {
+		if isNamespaceExists(createErr) {
+			err = newError(ErrDuplicate, "Collection '"+name+"' already exists.")
+		} else {
+			err = driverError(createErr, "Creating view '"+name+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     createErr.Error(),
+			"collection": name,
+			"source":     source,
+		}).Error("Mongo Error: Creating view failed.")
+	}
+	return
+}
+
+// Drops the collection with all its documents and indexes.
+// Must be confirmed if Safety is set.
+func (ma DataProvider) DropCollection(name string) (err *utils.Error) {
+
+	op, err := ma.begin("dropCollection", name)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if err = ma.confirm(ActionDropCollection, name); err != nil {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(30 * time.Second)
+
+	dropErr := sessionCopy.DB(ma.Database).C(name).DropCollection()
+	if dropErr != nil {
+		if isNamespaceNotFound(dropErr) || dropErr.Error() == "ns not found" {
+			err = newError(ErrNotFound, "Collection '"+name+"' not found.")
+		} else {
+			err = driverError(dropErr, "Dropping collection '"+name+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     dropErr.Error(),
+			"collection": name,
+		}).Error("Mongo Error: Dropping collection failed.")
+		return
+	}
+
+	ma.uncacheAll(name)
+	ma.InvalidateQueries(name)
+	ma.logger().WithFields(LogFields{
+		"collection": name,
+	}).Warning("Mongo Warning: Collection dropped.")
+	return
+}
+
+// Renames the collection. If dropTarget is set an existing collection
+// named 'to' is dropped, which must be confirmed if Safety is set.
+func (ma DataProvider) RenameCollection(from, to string, dropTarget bool) (err *utils.Error) {
+
+	op, err := ma.begin("renameCollection", from)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if dropTarget {
+		if err = ma.confirm(ActionDropCollection, to); err != nil {
+			return
+		}
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(30 * time.Second)
+
+	renameErr := sessionCopy.DB("admin").Run(bson.D{
+		{Name: "renameCollection", Value: ma.Database + "." + from},
+		{Name: "to", Value: ma.Database + "." + to},
+		{Name: "dropTarget", Value: dropTarget},
+	}, nil)
+	if renameErr != nil {
+		if isNamespaceNotFound(renameErr) {
+			err = newError(ErrNotFound, "Collection '"+from+"' not found.")
+		} else if isNamespaceExists(renameErr) {
+			err = newError(ErrDuplicate, "Collection '"+to+"' already exists.")
+		} else {
+			err = driverError(renameErr, "Renaming collection '"+from+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     renameErr.Error(),
+			"collection": from,
+			"to":         to,
+		}).Error("Mongo Error: Renaming collection failed.")
+		return
+	}
+
+	ma.uncacheAll(from)
+	ma.uncacheAll(to)
+	ma.InvalidateQueries(from)
+	ma.InvalidateQueries(to)
+	return
+}
@@ -0,0 +1,60 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// options of the aggregate command that mgo's Pipe doesn't support
+type aggregateOptions struct {
+	allowDiskUse bool
+	maxTime      time.Duration
+	hint         []string
+}
+
+// Runs the aggregate command and reads all the results from its cursor.
+func (ma DataProvider) aggregate(session *mgo.Session, collection string, pipeline interface{}, options aggregateOptions, results interface{}) error {
+
+	db := session.DB(ma.Database)
+
+	command := bson.D{
+		{Name: "aggregate", Value: collection},
+		{Name: "pipeline", Value: pipeline},
+		{Name: "cursor", Value: bson.M{}},
+	}
+	if options.allowDiskUse {
+		command = append(command, bson.DocElem{Name: "allowDiskUse", Value: true})
+	}
+	if options.maxTime > 0 {
+		command = append(command, bson.DocElem{Name: "maxTimeMS", Value: int64(options.maxTime / time.Millisecond)})
+	}
+	if len(options.hint) > 0 {
+		command = append(command, bson.DocElem{Name: "hint", Value: hintDocument(options.hint)})
+	}
+
+	var result struct {
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+			Id         int64      `bson:"id"`
+		} `bson:"cursor"`
+	}
+	runErr := db.Run(command, &result)
+
+	return db.C(collection).NewIter(session, result.Cursor.FirstBatch, result.Cursor.Id, runErr).All(results)
+}
+
+// Returns the max execution time of a query. The maxTimeMS parameter can
+// lower MaxQueryTime but cannot exceed it.
+func (ma DataProvider) queryMaxTime(parameterMs int, hasParameter bool) time.Duration {
+
+	if !hasParameter || parameterMs <= 0 {
+		return ma.MaxQueryTime
+	}
+
+	maxTime := time.Duration(parameterMs) * time.Millisecond
+	if ma.MaxQueryTime > 0 && maxTime > ma.MaxQueryTime {
+		return ma.MaxQueryTime
+	}
+	return maxTime
+}
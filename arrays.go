@@ -0,0 +1,95 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Appends the values to the array field, creating it if it doesn't exist.
+// The response contains the updated array in the field.
+// Example Usage:
+// provider.AppendToArray("tickets", id, "comments", []interface{}{comment})
+func (ma DataProvider) AppendToArray(collection, id, field string, values []interface{}) (response map[string]interface{}, err *utils.Error) {
+	return ma.arrayOperation("appendToArray", "$push", collection, id, field, values)
+}
+
+// Removes all the occurrences of the values from the array field.
+// Example Usage:
+// provider.RemoveFromArray("projects", id, "members", []interface{}{userId})
+func (ma DataProvider) RemoveFromArray(collection, id, field string, values []interface{}) (response map[string]interface{}, err *utils.Error) {
+	return ma.arrayOperation("removeFromArray", "$pull", collection, id, field, values)
+}
+
+// Appends the values that the array field doesn't contain yet.
+// Example Usage:
+// provider.AddToSetUnique("posts", id, "tags", []interface{}{"go", "mongo"})
+func (ma DataProvider) AddToSetUnique(collection, id, field string, values []interface{}) (response map[string]interface{}, err *utils.Error) {
+	return ma.arrayOperation("addToSetUnique", "$addToSet", collection, id, field, values)
+}
+
+func (ma DataProvider) arrayOperation(name, operator, collection, id, field string, values []interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin(name, collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+	if err = ma.checkModifiedField(collection, field); err != nil {
+		return
+	}
+	if len(values) == 0 {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Values cannot be empty for array operations.",
+		}
+		return
+	}
+
+	change := bson.M{operator: bson.M{field: bson.M{"$each": values}}}
+	if operator == "$pull" {
+		change = bson.M{operator: bson.M{field: bson.M{"$in": values}}}
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+
+	after, err := ma.modify(sessionCopy, collection, id, change, nil)
+	if err != nil {
+		return
+	}
+
+	op.countWritten(after)
+	response = ma.modifyResponse(collection, after)
+	response[field], _ = lookupField(after, field)
+	return
+}
+
+// Returns 400 if the field cannot be changed by the operators, which are
+// the fields maintained by the provider and the encrypted fields.
+func (ma DataProvider) checkModifiedField(collection, field string) (err *utils.Error) {
+
+	createdAtField, updatedAtField := ma.TimestampFieldNames(collection)
+	switch {
+	case field == "" || strings.HasPrefix(field, "$"):
+		err = newError(ErrBadFilter, "Field '"+field+"' is not valid.")
+	case field == ID || field == Version || field == DeletedAt || field == createdAtField || field == updatedAtField:
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Field '" + field + "' is maintained by the database and cannot be changed.",
+		}
+	case ma.Encryption != nil && containsString(ma.Encryption.Fields[collection], field):
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Encrypted field '" + field + "' cannot be changed by operators.",
+		}
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"reflect"
+	"strings"
+)
+
+// suffix of the collections that the audit trail is written to
+const HistorySuffix = "_history"
+
+// key of the requestscope that AuditSnapshot stores the document in
+const auditBeforeKey = "mongoutil.auditBefore"
+
+// Extras of the AuditSnapshot and AuditTrail interceptors.
+type AuditOptions struct {
+	// key of the requestscope that holds the actor, defaults to "userId"
+	ActorKey string
+}
+
+func (o AuditOptions) actorKey() string {
+	if o.ActorKey == "" {
+		return "userId"
+	}
+	return o.ActorKey
+}
+
+// Stores the document that is about to be updated or deleted in the request
+// scope, so AuditTrail can record the previous values of the changed fields.
+// Must be added to PUT and DELETE requests before the execution.
+// Example Usage:
+// core.Interceptors.Add("/users/{id}", methods.Put, interceptors.BEFORE_EXEC, mongoutil.AuditSnapshot, nil)
+// core.Interceptors.Add("/users/{id}", methods.Delete, interceptors.BEFORE_EXEC, mongoutil.AuditSnapshot, nil)
+func AuditSnapshot(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	collection, id := splitResource(req.Res)
+	if id == "" {
+		return
+	}
+
+	// a missing document is reported by the request itself
+	before, getErr := db.Get(collection, id)
+	if getErr == nil {
+		rs.Set(auditBeforeKey, before)
+	}
+	return
+}
+
+// Records the create, update and delete requests into the <collection>_history
+// collection with the actor, the changed fields and their previous values.
+// The history can be read through the Query API like any other collection.
+// Must be added to POST, PUT and DELETE requests after the execution.
+// Example Usage:
+// core.Interceptors.Add("/users", methods.Post, interceptors.AFTER_EXEC, mongoutil.AuditTrail, mongoutil.AuditOptions{ActorKey: "userId"})
+// core.Interceptors.Add("/users/{id}", methods.Put, interceptors.AFTER_EXEC, mongoutil.AuditTrail, nil)
+func AuditTrail(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	options, _ := extras.(AuditOptions)
+	collection, id := splitResource(req.Res)
+
+	var action string
+	var changes map[string]interface{}
+	before, _ := rs.Get(auditBeforeKey).(map[string]interface{})
+
+	// the values of the encrypted fields are not recorded, since the history
+	// would keep them in plaintext
+	skipped, encrypted := UpdatedAt, []string(nil)
+	if provider, isProvider := db.(*DataProvider); isProvider {
+		_, skipped = provider.TimestampFieldNames(collection)
+		if provider.Encryption != nil {
+			encrypted = provider.Encryption.Fields[collection]
+		}
+	}
+
+	switch strings.ToLower(req.Command) {
+	case methods.Post:
+		action = ChangeInsert
+		id, _ = res.Body[ID].(string)
+		changes = fieldChanges(nil, req.Body, skipped, encrypted)
+	case methods.Put:
+		action = ChangeUpdate
+		changes = fieldChanges(before, req.Body, skipped, encrypted)
+	case methods.Delete:
+		action = ChangeDelete
+		changes = fieldChanges(before, nil, skipped, encrypted)
+	default:
+		return
+	}
+
+	entry := map[string]interface{}{
+		"documentId": id,
+		"action":     action,
+		"actor":      rs.Get(options.actorKey()),
+		"changes":    changes,
+	}
+
+	// the request already succeeded so failing to record it is only logged
+	if _, createErr := db.Create(collection+HistorySuffix, entry); createErr != nil {
+		auditLogger(db).WithFields(LogFields{
+			"reason":     createErr.Error(),
+			"collection": collection,
+			"id":         id,
+		}).Error("Mongo Error: Recording audit trail failed.")
+	}
+	return
+}
+
+// Returns the fields that differ as field -> {"from": previous, "to": new}.
+// If after is nil all the fields of before are reported as removed. The
+// skipped field, i.e. updatedAt, is left out and the encrypted fields are
+// reported as field -> {"encrypted": true} without their values.
+func fieldChanges(before, after map[string]interface{}, skipped string, encrypted []string) (changes map[string]interface{}) {
+
+	changes = make(map[string]interface{})
+	change := func(field string, from, to interface{}) {
+		if containsString(encrypted, field) {
+			changes[field] = map[string]interface{}{"encrypted": true}
+			return
+		}
+		changes[field] = map[string]interface{}{"from": from, "to": to}
+	}
+
+	if after == nil {
+		for k, v := range before {
+			change(k, v, nil)
+		}
+		return
+	}
+
+	for k, v := range after {
+		if k == skipped {
+			continue
+		}
+		previous := before[k]
+		if !reflect.DeepEqual(previous, v) {
+			change(k, previous, v)
+		}
+	}
+	return
+}
+
+// Splits a resource path like "/users/123" into its collection and id.
+func splitResource(res string) (collection, id string) {
+
+	parts := strings.Split(strings.Trim(res, "/"), "/")
+	collection = parts[0]
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+	return
+}
+
+// Returns the logger of the provider recording the trail.
+func auditLogger(db dataprovider.Provider) logEntry {
+	if provider, isProvider := db.(*DataProvider); isProvider {
+		return provider.logger()
+	}
+	return newLogEntry(nil)
+}
@@ -0,0 +1,269 @@
+package mongoutil
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"gopkg.in/mgo.v2/bson"
+	"sync"
+	"time"
+)
+
+// Storage of the cached documents. The values are bson encoded so any store
+// that keeps bytes, e.g. Redis, can be plugged in with a small adapter.
+// Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(key string) (value []byte, found bool)
+	Set(key string, value []byte, ttl time.Duration)
+	Delete(key string)
+}
+
+// In-memory Cache evicting the least recently used entries when it is full.
+// Example Usage:
+// provider.Cache = mongoutil.NewLRUCache(10000)
+// provider.CacheTTL = map[string]time.Duration{"countries": time.Hour}
+type LRUCache struct {
+	capacity int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *LRUCache) Get(key string) (value []byte, found bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	if element, exists := c.entries[key]; exists {
+		entry := element.Value.(*lruEntry)
+		entry.value, entry.expiresAt = value, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, exists := c.entries[key]; exists {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+func (ma DataProvider) isCached(collection string) bool {
+	_, hasTTL := ma.CacheTTL[collection]
+	return ma.Cache != nil && hasTTL
+}
+
+// the database is part of the key since the tenants share the cache. the
+// generation of the collection is replaced when it is dropped or renamed
+func (ma DataProvider) cacheKey(collection, id string) string {
+	return "doc:" + ma.Database + "/" + collection + "/" + ma.itemsGeneration(collection) + "/" + id
+}
+
+func (ma DataProvider) itemsGeneration(collection string) string {
+
+	generation, found := ma.Cache.Get("items:" + ma.Database + "/" + collection)
+	if !found {
+		generation = []byte(bson.NewObjectId().Hex())
+		ma.Cache.Set("items:"+ma.Database+"/"+collection, generation, 0)
+	}
+	return string(generation)
+}
+
+// Removes all the cached documents of the collection, e.g. when it is dropped.
+func (ma DataProvider) uncacheAll(collection string) {
+	if ma.isCached(collection) {
+		ma.Cache.Set("items:"+ma.Database+"/"+collection, []byte(bson.NewObjectId().Hex()), 0)
+	}
+}
+
+// Returns the cached document. Documents are cached as they are stored,
+// so the encrypted fields stay encrypted in the cache.
+func (ma DataProvider) cachedItem(collection, id string) (item map[string]interface{}, found bool) {
+
+	if !ma.isCached(collection) {
+		return
+	}
+	value, found := ma.Cache.Get(ma.cacheKey(collection, id))
+	if !found {
+		return
+	}
+	if unmarshalErr := bson.Unmarshal(value, &item); unmarshalErr != nil {
+		ma.uncache(collection, id)
+		return nil, false
+	}
+	return
+}
+
+// Returns the generation of the cached document, which is replaced by every
+// write changing the document. It is read before the document is read from the
+// database, so that a document read before a write is not cached after it.
+func (ma DataProvider) itemGeneration(collection, id string) string {
+	if !ma.isCached(collection) {
+		return ""
+	}
+	key := ma.cacheKey(collection, id)
+	generation, _ := ma.Cache.Get("gen:" + key)
+	return key + "@" + string(generation)
+}
+
+// Caches the document unless it was changed since the generation was read.
+func (ma DataProvider) cacheItem(collection, id string, item map[string]interface{}, generation string) {
+
+	if !ma.isCached(collection) || ma.itemGeneration(collection, id) != generation {
+		return
+	}
+	value, marshalErr := bson.Marshal(item)
+	if marshalErr != nil {
+		ma.logger().WithFields(LogFields{
+			"reason":     marshalErr.Error(),
+			"collection": collection,
+			"id":         id,
+		}).Error("Mongo Error: Caching item failed.")
+		return
+	}
+	ma.Cache.Set(ma.cacheKey(collection, id), value, ma.CacheTTL[collection])
+}
+
+// Removes the document from the cache and replaces its generation. Called by
+// the writes changing it.
+func (ma DataProvider) uncache(collection, id string) {
+	if ma.isCached(collection) {
+		ma.Cache.Set("gen:"+ma.cacheKey(collection, id), []byte(bson.NewObjectId().Hex()), ma.CacheTTL[collection])
+		ma.Cache.Delete(ma.cacheKey(collection, id))
+	}
+}
+
+func (ma DataProvider) isQueryCached(collection string) bool {
+	_, hasTTL := ma.QueryCacheTTL[collection]
+	return ma.Cache != nil && hasTTL
+}
+
+// Queries are cached under the current generation of the collection, which is
+// replaced by every write, so the instances sharing the cache stop reading the
+// results of all the queries of the collection at once.
+func (ma DataProvider) queryCacheKey(collection string, parameters map[string][]string) (key string, ok bool) {
+
+	generation, found := ma.Cache.Get("gen:" + ma.Database + "/" + collection)
+	if !found {
+		generation = []byte(bson.NewObjectId().Hex())
+		ma.Cache.Set("gen:"+ma.Database+"/"+collection, generation, 0)
+	}
+
+	// json encoding sorts the keys so equal parameters give the same hash
+	encoded, marshalErr := json.Marshal(parameters)
+	if marshalErr != nil {
+		return
+	}
+	hash := sha1.Sum(encoded)
+	return "query:" + ma.Database + "/" + collection + "/" + string(generation) + "/" + hex.EncodeToString(hash[:]), true
+}
+
+// a page of query results as it is cached
+type cachedPage struct {
+	Results []map[string]interface{} `bson:"results"`
+	Total   int                      `bson:"total"`
+	HasMore bool                     `bson:"hasMore"`
+}
+
+// Returns the cached results of the query, as they are stored, and the key
+// that the results read from the database are cached under.
+func (ma DataProvider) cachedResults(collection string, parameters map[string][]string) (page cachedPage, found bool, key string) {
+
+	if !ma.isQueryCached(collection) {
+		return
+	}
+	key, ok := ma.queryCacheKey(collection, parameters)
+	if !ok {
+		return
+	}
+	value, found := ma.Cache.Get(key)
+	if !found {
+		return
+	}
+
+	if unmarshalErr := bson.Unmarshal(value, &page); unmarshalErr != nil {
+		ma.Cache.Delete(key)
+		return cachedPage{}, false, key
+	}
+	return page, true, key
+}
+
+// Caches the results under the key returned by cachedResults before they were read.
+func (ma DataProvider) cacheResults(collection, key string, page cachedPage) {
+
+	if !ma.isQueryCached(collection) || key == "" {
+		return
+	}
+	value, marshalErr := bson.Marshal(page)
+	if marshalErr != nil {
+		ma.logger().WithFields(LogFields{
+			"reason":     marshalErr.Error(),
+			"collection": collection,
+		}).Error("Mongo Error: Caching query results failed.")
+		return
+	}
+	ma.Cache.Set(key, value, ma.QueryCacheTTL[collection])
+}
+
+// Invalidates the cached query results of the collection. Called by every
+// write to the collection, and can be called by the applications writing to
+// the collection by other means.
+// Example Usage:
+// provider.InvalidateQueries("products")
+func (ma DataProvider) InvalidateQueries(collection string) {
+	if ma.isQueryCached(collection) {
+		ma.Cache.Set("gen:"+ma.Database+"/"+collection, []byte(bson.NewObjectId().Hex()), 0)
+	}
+}
@@ -0,0 +1,103 @@
+package mongoutil
+
+import (
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// rules of Relation.OnDelete
+const (
+	// deletes the related documents through Delete, so their own
+	// soft delete settings, hooks and cascades apply
+	CascadeDelete = "delete"
+	// sets the ForeignField of the related documents to null
+	CascadeNullify = "nullify"
+)
+
+// Returns whether the document must be read before it is deleted to apply the
+// cascade rules, which is the case if a rule refers to a field other than _id.
+func (ma DataProvider) needsCascadeDocument(collection string) bool {
+	for _, relation := range ma.Relations[collection] {
+		if relation.OnDelete != "" && relation.LocalField != ID {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the OnDelete rules of the relations of the deleted document. The
+// related documents of join relations are kept, only their joins are removed.
+func (ma DataProvider) cascadeDelete(session *mgo.Session, collection string, id interface{}, document map[string]interface{}) (err *utils.Error) {
+
+	for _, relation := range ma.Relations[collection] {
+		if relation.OnDelete == "" {
+			continue
+		}
+
+		var values []interface{}
+		if relation.LocalField == ID {
+			values = []interface{}{id}
+		} else if document != nil {
+			values = relationValues(document[relation.LocalField])
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		var cascadeErr error
+		switch {
+		case relation.Join != "":
+			_, cascadeErr = session.DB(ma.Database).C(relation.Join).RemoveAll(bson.M{relation.JoinLocalField: bson.M{"$in": values}})
+		case relation.OnDelete == CascadeNullify:
+			filter := bson.M{relation.foreignField(): bson.M{"$in": values}}
+			// the ids of the updated documents are needed to evict them from the cache
+			var related []map[string]interface{}
+			if ma.isCached(relation.Collection) {
+				cascadeErr = session.DB(ma.Database).C(relation.Collection).Find(filter).Select(bson.M{ID: 1}).All(&related)
+			}
+			if cascadeErr == nil {
+				_, cascadeErr = session.DB(ma.Database).C(relation.Collection).UpdateAll(
+					filter,
+					bson.M{"$set": bson.M{relation.foreignField(): nil}},
+				)
+			}
+			for _, r := range related {
+				ma.uncache(relation.Collection, fmt.Sprint(hexId(r[ID])))
+			}
+		case relation.OnDelete == CascadeDelete:
+			var related []map[string]interface{}
+			cascadeErr = session.DB(ma.Database).C(relation.Collection).
+				Find(ma.excludeDeleted(relation.Collection, bson.M{relation.foreignField(): bson.M{"$in": values}})).
+				Select(bson.M{ID: 1}).All(&related)
+			if cascadeErr == nil {
+				for _, r := range related {
+					if _, err = ma.Delete(relation.Collection, fmt.Sprint(hexId(r[ID]))); err != nil && KindOf(err) != ErrNotFound {
+						return
+					}
+					err = nil
+				}
+			}
+		}
+
+		if relation.Join != "" {
+			ma.InvalidateQueries(relation.Join)
+		} else {
+			ma.InvalidateQueries(relation.Collection)
+		}
+
+		if cascadeErr != nil {
+			err = driverError(cascadeErr, "Cascading delete of '"+collection+"' to '"+relation.Collection+"' failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason":     cascadeErr.Error(),
+				"collection": collection,
+				"relation":   relation.Name,
+				"id":         id,
+			}).Error("Mongo Error: Cascading delete failed.")
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,52 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/requestscope"
+	"gopkg.in/mgo.v2"
+	"sync/atomic"
+)
+
+// requestscope key of the causal session shared by the providers of a request
+const CausalSessionKey = "mongoCausalSession"
+
+// Tracks whether a request has written. mgo doesn't support the causally
+// consistent sessions of the server, so once the request writes, its reads
+// are sent to the primary instead of the secondaries that may lag behind.
+type causalSession struct {
+	written int32
+}
+
+// Returns a copy of the provider whose reads see the writes made earlier in
+// the same request, even for the collections read from the secondaries. The
+// session is kept in the requestscope, so all the providers created for the
+// request share it.
+// Example Usage:
+//
+//	provider := baseProvider.WithCausalConsistency(rs)
+//	created, _ := provider.Create("orders", order)
+//	provider.Get("orders", created[mongoutil.ID].(string)) // never misses the new order
+func (ma DataProvider) WithCausalConsistency(rs requestscope.RequestScope) *DataProvider {
+
+	causal, hasSession := rs.Get(CausalSessionKey).(*causalSession)
+	if !hasSession {
+		causal = &causalSession{}
+		rs.Set(CausalSessionKey, causal)
+	}
+	ma.causal = causal
+	return &ma
+}
+
+// Records the write of the request, or routes the read to the primary if the request has written.
+func (ma DataProvider) applyCausality(session *mgo.Session, read bool) {
+
+	if ma.causal == nil {
+		return
+	}
+	if !read {
+		atomic.StoreInt32(&ma.causal.written, 1)
+		return
+	}
+	if atomic.LoadInt32(&ma.causal.written) == 1 {
+		session.SetMode(mgo.Primary, false)
+	}
+}
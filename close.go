@@ -0,0 +1,119 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/mgo.v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// runtime state shared by the copies of the provider, since the
+// operations are implemented on value receivers
+type providerState struct {
+	inFlight sync.WaitGroup
+	// guards closing and session against the operations that are beginning
+	lifecycle sync.RWMutex
+	closing   bool
+	// the connected session. copies of the provider made before it was closed
+	// hold the closed session and are rejected by begin
+	session *mgo.Session
+
+	readOnly int32
+	counters operationCounters
+
+	logSamples logSamples
+
+	mutex         sync.RWMutex
+	drift         DriftReport
+	subscriptions map[string][]*Subscription
+	usage         map[string]map[string]*Usage
+	schemas       map[string]*gojsonschema.Schema
+	hooks         map[string]map[string][]Hook
+	// indexes and collections that are created once per provider
+	ensuredIndexes map[string]bool
+	connectedAt    time.Time
+}
+
+// Stops accepting new operations, waits for the in-flight operations to finish
+// and closes the session. The operations beginning after Close is called are
+// rejected with 503. If the operations don't finish in the given timeout an
+// error is returned and the session is closed once they finish. The provider
+// can be connected again after it is closed.
+// Example Usage:
+// defer provider.Close(10 * time.Second)
+func (ma *DataProvider) Close(timeout time.Duration) (err *utils.Error) {
+
+	if ma.session == nil {
+		return
+	}
+
+	if ma.state != nil {
+		ma.state.lifecycle.Lock()
+		ma.state.closing = true
+		ma.state.lifecycle.Unlock()
+
+		drained := make(chan struct{})
+		go func() {
+			ma.state.inFlight.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+		case <-time.After(timeout):
+			err = &utils.Error{
+				Code:    http.StatusServiceUnavailable,
+				Message: "In-flight operations did not finish before closing the session.",
+			}
+
+			ma.logger().Error("Mongo Error: In-flight operations did not finish. Session is closed once they finish.")
+
+			// closing the session under the running operations would fail them.
+			// new operations are rejected until they finish, since the in-flight
+			// counter must not be increased while it is waited for
+			session := ma.session
+			ma.detachSession(false)
+			go func() {
+				<-drained
+				session.Close()
+				ma.reopen()
+			}()
+		}
+	}
+
+	if err == nil {
+		ma.session.Close()
+		ma.detachSession(true)
+	}
+
+	if ma.StatsD != nil {
+		ma.StatsD.Close()
+	}
+	return
+}
+
+// Forgets the closed session. The operations of the provider and its copies
+// are rejected until it is connected again.
+func (ma *DataProvider) detachSession(reopen bool) {
+
+	if ma.state == nil {
+		ma.session = nil
+		return
+	}
+	ma.state.lifecycle.Lock()
+	ma.session = nil
+	ma.state.session = nil
+	if reopen {
+		ma.state.closing = false
+	}
+	ma.state.lifecycle.Unlock()
+}
+
+// Allows the operations to begin again once the provider is connected.
+func (ma DataProvider) reopen() {
+	ma.state.lifecycle.Lock()
+	ma.state.closing = false
+	ma.state.lifecycle.Unlock()
+}
@@ -6,6 +6,20 @@ const (
 	CreatedAt = "createdAt"
 	UpdatedAt = "updatedAt"
 
+	// written only for the collections configured in Versioning
+	Version = "_version"
+
+	// written only for the collections configured in SoftDelete
+	DeletedAt = "deletedAt"
+
+	// written only for the collections configured in AccessTracking
+	LastAccessedAt = "lastAccessedAt"
+
 	// field used to return lists
 	List      = "results"
+
+	// fields of the write responses with the number of the affected documents
+	MatchedCount  = "matchedCount"
+	ModifiedCount = "modifiedCount"
+	DeletedCount  = "deletedCount"
 )
@@ -0,0 +1,143 @@
+package mongoutil
+
+import (
+	"time"
+)
+
+// Returns the filter matching the documents whose date field is in [from, to).
+// A zero from or to leaves the range open on that side. The dates are given as
+// RFC 3339 strings, which Query converts to the stored representation of the field.
+// Example Usage:
+// where, _ := json.Marshal(mongoutil.DateRange("createdAt", time.Now().AddDate(0, 0, -7), time.Time{}))
+// response, err := provider.Query("orders", map[string][]string{"where": {string(where)}})
+func DateRange(field string, from, to time.Time) map[string]interface{} {
+
+	conditions := make(map[string]interface{})
+	if !from.IsZero() {
+		conditions["$gte"] = from.UTC().Format(time.RFC3339Nano)
+	}
+	if !to.IsZero() {
+		conditions["$lt"] = to.UTC().Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{field: conditions}
+}
+
+// operators whose values are compared with the values of the fields
+var comparisonOperators = map[string]bool{
+	"$eq":  true,
+	"$ne":  true,
+	"$gt":  true,
+	"$gte": true,
+	"$lt":  true,
+	"$lte": true,
+	"$in":  true,
+	"$nin": true,
+}
+
+// Returns the functions converting the values compared with the fields of the
+// collection to the representations the fields are stored in. The date fields
+// are the timestamps written by the provider and the fields declared as
+// TypeDate, and the decimal fields are the ones declared as TypeDecimal.
+func (ma DataProvider) filterParsers(collection string) map[string]func(interface{}) interface{} {
+
+	timestamp := func(value interface{}) interface{} {
+		return parseDate(value, func(t time.Time) interface{} { return ma.TimestampMode.value(t) })
+	}
+	date := func(value interface{}) interface{} {
+		return parseDate(value, func(t time.Time) interface{} { return t })
+	}
+	decimal := func(value interface{}) interface{} {
+		if parsed, ok := coerceValue(TypeDecimal, value); ok {
+			return parsed
+		}
+		return value
+	}
+
+	parsers := map[string]func(interface{}) interface{}{
+		DeletedAt:      timestamp,
+		LastAccessedAt: timestamp,
+	}
+	createdAtField, updatedAtField := ma.TimestampFieldNames(collection)
+	for _, field := range []string{createdAtField, updatedAtField} {
+		if field != "" {
+			parsers[field] = timestamp
+		}
+	}
+	for field, fieldType := range ma.FieldTypes[collection] {
+		switch fieldType {
+		case TypeDate:
+			parsers[field] = date
+		case TypeDecimal:
+			parsers[field] = decimal
+		}
+	}
+	return parsers
+}
+
+// Converts the values compared with the date and decimal fields in the filter
+// to the representations the fields are stored in, so the clients can filter
+// by dates given as ISO 8601 strings without knowing how the timestamps are
+// stored, and by decimals given as strings or numbers. The filter is changed
+// in place and the values that cannot be converted are kept.
+func (ma DataProvider) parseFilterValues(collection string, filter interface{}) {
+
+	conditions, isMap := filter.(map[string]interface{})
+	if !isMap {
+		return
+	}
+	parsers := ma.filterParsers(collection)
+
+	var convert func(conditions map[string]interface{})
+	convert = func(conditions map[string]interface{}) {
+		for key, value := range conditions {
+			if logicalOperators[key] {
+				clauses, _ := value.([]interface{})
+				for _, clause := range clauses {
+					if clauseConditions, isClauseMap := clause.(map[string]interface{}); isClauseMap {
+						convert(clauseConditions)
+					}
+				}
+				continue
+			}
+
+			parse, hasParser := parsers[key]
+			if !hasParser {
+				continue
+			}
+			operators, hasOperators := value.(map[string]interface{})
+			if !hasOperators || !hasOperatorKeys(operators) {
+				conditions[key] = parse(value)
+				continue
+			}
+			for operator, operand := range operators {
+				if !comparisonOperators[operator] {
+					continue
+				}
+				if list, isList := operand.([]interface{}); isList {
+					for i := range list {
+						list[i] = parse(list[i])
+					}
+					continue
+				}
+				operators[operator] = parse(operand)
+			}
+		}
+	}
+	convert(conditions)
+}
+
+// Returns the value in the representation if it is an ISO 8601 string,
+// either a date and time or only a date.
+func parseDate(value interface{}, representation func(time.Time) interface{}) interface{} {
+
+	text, isString := value.(string)
+	if !isString {
+		return value
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, parseErr := time.Parse(layout, text); parseErr == nil {
+			return representation(t)
+		}
+	}
+	return value
+}
@@ -0,0 +1,132 @@
+package mongoutil
+
+import (
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// field of the DeleteMany response listing the ids that were deleted
+const Deleted = "deleted"
+
+// Deletes the documents with the given ids in a single write. The deleted ids
+// are returned in "deleted" and the ids that were not found in "missing".
+// Must be confirmed if Safety is set.
+// Example Usage:
+// response, err := provider.WithConfirmation(token).DeleteMany("users", []string{"a1", "b2"})
+func (ma DataProvider) DeleteMany(collection string, ids []string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("deleteMany", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if err = ma.confirm(ActionDeleteMany, collection); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 5*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
+
+	for _, id := range ids {
+		if err = ma.runHooks(BeforeDelete, collection, id, nil); err != nil {
+			return
+		}
+	}
+
+	// the documents are read first to tell the deleted ids from the missing ones.
+	// only their ids are read unless someone will receive them or the cascade
+	// rules need their fields
+	var documents []map[string]interface{}
+	if len(ids) > 0 {
+		query := connection.Find(ma.excludeDeleted(collection, bson.M{ID: bson.M{"$in": ma.storedIds(collection, ids)}}))
+		if !ma.hasSubscribers(collection) && !ma.needsCascadeDocument(collection) {
+			query = query.Select(bson.M{ID: 1})
+		}
+		findErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			return query.All(&documents)
+		})
+		if findErr != nil {
+			err = driverError(findErr, "Getting '"+collection+"' items failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason":     findErr.Error(),
+				"collection": collection,
+				"ids":        ids,
+			}).Error("Mongo Error: Getting items failed.")
+			return
+		}
+	}
+
+	storedIds := make([]interface{}, 0, len(documents))
+	deleted := make([]string, 0, len(documents))
+	for _, document := range documents {
+		storedIds = append(storedIds, document[ID])
+		deleted = append(deleted, fmt.Sprint(hexId(document[ID])))
+	}
+
+	var deletedAt interface{}
+	if len(storedIds) > 0 {
+		filter := bson.M{ID: bson.M{"$in": storedIds}}
+		removeErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			if ma.isSoftDelete(collection) {
+				deletedAt = ma.now()
+				_, err = connection.UpdateAll(filter, bson.M{"$set": bson.M{DeletedAt: deletedAt}})
+				return
+			}
+			_, err = connection.RemoveAll(filter)
+			return
+		})
+		if removeErr != nil {
+			err = driverError(removeErr, "Deleting '"+collection+"' items failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason":     removeErr.Error(),
+				"collection": collection,
+				"ids":        deleted,
+			}).Error("Mongo Error: Deleting items failed.")
+			return
+		}
+	}
+
+	ma.InvalidateQueries(collection)
+	for i, document := range documents {
+		ma.uncache(collection, deleted[i])
+		op.countWritten(document)
+		ma.publishChange(ChangeEvent{Type: ChangeDelete, Collection: collection, ID: deleted[i], Before: document})
+		ma.runAfterHooks(AfterDelete, collection, deleted[i], document)
+
+		if err = ma.cascadeDelete(sessionCopy, collection, document[ID], document); err != nil {
+			return
+		}
+	}
+
+	deletedIds := make(map[string]bool, len(deleted))
+	for _, id := range deleted {
+		deletedIds[id] = true
+	}
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if !deletedIds[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	response = map[string]interface{}{
+		Deleted:      deleted,
+		Missing:      missing,
+		DeletedCount: len(deleted),
+	}
+	if ma.isSoftDelete(collection) && len(deleted) > 0 {
+		response[DeletedAt] = deletedAt
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Returns a copy of the provider that works on the documents of the type in
+// the collections with a discriminator. Queries only return the documents of
+// the type and created documents get the type set.
+// Example Usage:
+// cars, err := provider.WithType("car").Query("vehicles", parameters)
+func (ma DataProvider) WithType(typeName string) *DataProvider {
+	ma.entityType = typeName
+	return &ma
+}
+
+// Registers the JSON Schema that the documents of the type are validated against,
+// in addition to the schema of the collection. Type schemas are not pushed to
+// the server since the validators of mongo apply to the whole collection.
+// Example Usage:
+//
+//	err := provider.RegisterTypeSchema("vehicles", "car", map[string]interface{}{
+//	    "type":     "object",
+//	    "required": []string{"seats"},
+//	})
+func (ma *DataProvider) RegisterTypeSchema(collection, typeName string, schema map[string]interface{}) (err *utils.Error) {
+	return ma.RegisterSchema(typeKey(collection, typeName), schema, false)
+}
+
+// Registers the hook to be run for the event on the documents of the type,
+// after the hooks of the collection. The type is read from the document, or
+// from WithType for the events without one, e.g. BeforeDelete.
+// Example Usage:
+//
+//	provider.RegisterTypeHook(mongoutil.BeforeCreate, "vehicles", "car", func(collection string, id interface{}, car map[string]interface{}) *utils.Error {
+//	    car["wheels"] = 4
+//	    return nil
+//	})
+func (ma *DataProvider) RegisterTypeHook(event, collection, typeName string, hook Hook) {
+	ma.RegisterHook(event, typeKey(collection, typeName), hook)
+}
+
+// Returns the key that the schemas and the hooks of the type are registered with.
+func typeKey(collection, typeName string) string {
+	return collection + "/" + typeName
+}
+
+// Returns the type of the document, which is the type of the provider if
+// the document doesn't have one.
+func (ma DataProvider) documentType(collection string, document map[string]interface{}) string {
+
+	field, hasField := ma.Discriminators[collection]
+	if !hasField {
+		return ""
+	}
+	if typeName, hasType := document[field].(string); hasType {
+		return typeName
+	}
+	return ma.entityType
+}
+
+// Sets the type of the provider to the document to be created. Returns 400 if the
+// document doesn't have a type or has another type than the provider.
+func (ma DataProvider) stampType(collection string, document map[string]interface{}) (err *utils.Error) {
+
+	field, hasField := ma.Discriminators[collection]
+	if !hasField {
+		return
+	}
+
+	value, hasType := document[field]
+	if !hasType && ma.entityType != "" {
+		document[field] = ma.entityType
+		return
+	}
+	if typeName, isString := value.(string); !isString || typeName == "" {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Input must contain '" + field + "' field.",
+		}
+		return
+	}
+	if ma.entityType != "" && value != ma.entityType {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Input cannot contain another type than '" + ma.entityType + "'.",
+		}
+	}
+	return
+}
+
+// Returns true if the update contains the discriminator, which must be
+// checked against the stored document.
+func (ma DataProvider) updatesType(collection string, data map[string]interface{}) bool {
+	field, hasField := ma.Discriminators[collection]
+	if !hasField {
+		return false
+	}
+	_, hasType := data[field]
+	return hasType
+}
+
+// Returns 400 if the update changes the type of the stored document, which
+// would skip the validation and the hooks of the new type.
+func (ma DataProvider) checkTypeChange(collection string, stored, data map[string]interface{}) (err *utils.Error) {
+
+	field := ma.Discriminators[collection]
+	if value, hasType := data[field]; hasType && !reflect.DeepEqual(value, stored[field]) {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Input cannot change '" + field + "' field.",
+		}
+	}
+	return
+}
+
+// Returns the filter restricting the queries of the collection to the type of the provider.
+func (ma DataProvider) typeFilter(collection string) (filter map[string]interface{}, hasFilter bool) {
+
+	field, hasField := ma.Discriminators[collection]
+	if !hasField || ma.entityType == "" {
+		return
+	}
+	return map[string]interface{}{field: ma.entityType}, true
+}
+
+// Returns true if a schema is registered for any type of the collection.
+func (ma DataProvider) hasTypeSchemas(collection string) bool {
+
+	if _, hasField := ma.Discriminators[collection]; !hasField || ma.state == nil {
+		return false
+	}
+	ma.state.mutex.RLock()
+	defer ma.state.mutex.RUnlock()
+	for key := range ma.state.schemas {
+		if strings.HasPrefix(key, collection+"/") {
+			return true
+		}
+	}
+	return false
+}
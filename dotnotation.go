@@ -0,0 +1,121 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Returns the reason if the dot-notation path, e.g. "address.city", is not a
+// valid field path. Operators and positional segments are not allowed.
+func fieldPathError(path string) string {
+
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" {
+			return "Field '" + path + "' cannot have empty segments."
+		}
+		if strings.HasPrefix(segment, "$") {
+			return "Field '" + path + "' cannot contain operators."
+		}
+	}
+	return ""
+}
+
+// Returns 400 if a field of the filter is not a valid dot-notation path. The
+// fields in $and, $or, $nor, $not and $elemMatch are checked as well, while the
+// embedded documents compared as values are not.
+func checkFilterPaths(filter interface{}) (err *utils.Error) {
+
+	conditions, isMap := filter.(map[string]interface{})
+	if !isMap {
+		return
+	}
+
+	for key, value := range conditions {
+		if logicalOperators[key] {
+			clauses, _ := value.([]interface{})
+			for _, clause := range clauses {
+				if err = checkFilterPaths(clause); err != nil {
+					return
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+
+		if reason := fieldPathError(key); reason != "" {
+			return newError(ErrBadFilter, reason)
+		}
+		operators, _ := value.(map[string]interface{})
+		for _, operator := range []string{"$not", "$elemMatch"} {
+			if err = checkFilterPaths(operators[operator]); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Returns 400 if a field of the update is not a valid dot-notation path, or
+// the update changes a field and its embedded field together, e.g. "address"
+// and "address.city", which mongo rejects as conflicting.
+func checkUpdatePaths(data map[string]interface{}) (err *utils.Error) {
+
+	for field := range data {
+		if reason := fieldPathError(field); reason != "" {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: reason,
+			}
+			return
+		}
+		for i := strings.LastIndex(field, "."); i > 0; i = strings.LastIndex(field[:i], ".") {
+			if _, hasParent := data[field[:i]]; hasParent {
+				err = &utils.Error{
+					Code:    http.StatusBadRequest,
+					Message: "Fields '" + field[:i] + "' and '" + field + "' cannot be updated together.",
+				}
+				return
+			}
+		}
+	}
+	return
+}
+
+// Sets the value of the dot-notation path like $set does, creating the missing
+// embedded documents. The embedded documents and arrays on the path are copied,
+// so the documents sharing them with the document are not changed.
+func setPath(document map[string]interface{}, path string, value interface{}) {
+
+	segments := strings.SplitN(path, ".", 2)
+	if len(segments) == 1 {
+		document[path] = value
+		return
+	}
+	field, rest := segments[0], segments[1]
+
+	if array, isArray := document[field].([]interface{}); isArray {
+		indexSegments := strings.SplitN(rest, ".", 2)
+		if index, indexErr := strconv.Atoi(indexSegments[0]); indexErr == nil && index >= 0 && index < len(array) {
+			copied := append([]interface{}{}, array...)
+			if len(indexSegments) == 1 {
+				copied[index] = value
+			} else {
+				element, _ := asObject(copied[index])
+				element = copyDocument(element)
+				setPath(element, indexSegments[1], value)
+				copied[index] = element
+			}
+			document[field] = copied
+			return
+		}
+	}
+
+	embedded, _ := asObject(document[field])
+	embedded = copyDocument(embedded)
+	setPath(embedded, rest, value)
+	document[field] = embedded
+}
@@ -0,0 +1,269 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// values of CollectionDrift.Validator
+const (
+	ValidatorMissing    = "missing"
+	ValidatorExtra      = "extra"
+	ValidatorMismatched = "mismatched"
+)
+
+// Differences between the declared Indexes/Validators and the server.
+type DriftReport struct {
+	CheckedAt   time.Time                  `json:"checkedAt"`
+	Collections map[string]CollectionDrift `json:"collections,omitempty"`
+}
+
+// Indexes are identified by their keys, e.g. "name,-createdAt".
+type CollectionDrift struct {
+	MissingIndexes    []string `json:"missingIndexes,omitempty"`
+	ExtraIndexes      []string `json:"extraIndexes,omitempty"`
+	MismatchedIndexes []string `json:"mismatchedIndexes,omitempty"`
+	Validator         string   `json:"validator,omitempty"`
+}
+
+func (r DriftReport) HasDrift() bool {
+	return len(r.Collections) > 0
+}
+
+// Returns the report produced by the last drift check. The check runs
+// at Connect and can be repeated with CheckDrift.
+func (ma DataProvider) Drift() (report DriftReport) {
+
+	if ma.state == nil {
+		return
+	}
+
+	ma.state.mutex.RLock()
+	defer ma.state.mutex.RUnlock()
+	return ma.state.drift
+}
+
+// Compares the declared indexes and validators with the ones on the server,
+// logs the differences and stores the report to be returned by Drift.
+func (ma DataProvider) CheckDrift() (report DriftReport, err *utils.Error) {
+
+	defer ma.recoverPanic(&operation{name: "checkDrift"}, &err)
+
+	report = DriftReport{
+		CheckedAt:   time.Now(),
+		Collections: make(map[string]CollectionDrift),
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(5 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+	db := sessionCopy.DB(ma.Database)
+
+	for collection, declared := range ma.Indexes {
+		existing, indexesErr := db.C(collection).Indexes()
+		if indexesErr != nil && !isNamespaceNotFound(indexesErr) {
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Listing indexes of '" + collection + "' failed.",
+			}
+
+			ma.logger().WithFields(LogFields{
+				"reason":     indexesErr.Error(),
+				"collection": collection,
+			}).Error("Mongo Error: Listing indexes failed.")
+			return
+		}
+
+		drift := diffIndexes(declared, existing)
+		if !reflect.DeepEqual(drift, CollectionDrift{}) {
+			report.Collections[collection] = drift
+		}
+	}
+
+	for collection, declared := range ma.Validators {
+		existing, hasCollection, validatorErr := collectionValidator(db, collection)
+		if validatorErr != nil {
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Getting validator of '" + collection + "' failed.",
+			}
+
+			ma.logger().WithFields(LogFields{
+				"reason":     validatorErr.Error(),
+				"collection": collection,
+			}).Error("Mongo Error: Getting validator failed.")
+			return
+		}
+
+		state := ""
+		if !hasCollection || existing == nil {
+			state = ValidatorMissing
+		} else if !reflect.DeepEqual(normalizeBson(declared), existing) {
+			state = ValidatorMismatched
+		}
+
+		if state != "" {
+			drift := report.Collections[collection]
+			drift.Validator = state
+			report.Collections[collection] = drift
+		}
+	}
+
+	// validators on the server that are not declared are only checked when
+	// the validators are managed by the provider
+	if ma.Validators != nil {
+		validated, listErr := validatedCollections(db)
+		if listErr != nil {
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Listing validated collections failed.",
+			}
+
+			ma.logger().WithFields(LogFields{
+				"reason": listErr.Error(),
+			}).Error("Mongo Error: Listing validated collections failed.")
+			return
+		}
+		for _, collection := range validated {
+			if _, isDeclared := ma.Validators[collection]; !isDeclared {
+				drift := report.Collections[collection]
+				drift.Validator = ValidatorExtra
+				report.Collections[collection] = drift
+			}
+		}
+	}
+
+	for collection, drift := range report.Collections {
+		ma.logger().WithFields(LogFields{
+			"collection":        collection,
+			"missingIndexes":    drift.MissingIndexes,
+			"extraIndexes":      drift.ExtraIndexes,
+			"mismatchedIndexes": drift.MismatchedIndexes,
+			"validator":         drift.Validator,
+		}).Warning("Mongo Warning: Index or validator configuration drifted.")
+	}
+
+	if ma.state != nil {
+		ma.state.mutex.Lock()
+		ma.state.drift = report
+		ma.state.mutex.Unlock()
+	}
+	return
+}
+
+func diffIndexes(declared, existing []mgo.Index) (drift CollectionDrift) {
+
+	existingByKey := make(map[string]mgo.Index)
+	for _, index := range existing {
+		// the _id index is created by the server for every collection
+		if index.Name == "_id_" {
+			continue
+		}
+		existingByKey[strings.Join(index.Key, ",")] = index
+	}
+
+	declaredKeys := make(map[string]bool)
+	for _, index := range declared {
+		key := strings.Join(index.Key, ",")
+		declaredKeys[key] = true
+
+		server, exists := existingByKey[key]
+		if !exists {
+			drift.MissingIndexes = append(drift.MissingIndexes, key)
+			continue
+		}
+
+		if index.Unique != server.Unique ||
+			index.Sparse != server.Sparse ||
+			index.ExpireAfter != server.ExpireAfter ||
+			(index.Name != "" && index.Name != server.Name) {
+			drift.MismatchedIndexes = append(drift.MismatchedIndexes, key)
+		}
+	}
+
+	for key := range existingByKey {
+		if !declaredKeys[key] {
+			drift.ExtraIndexes = append(drift.ExtraIndexes, key)
+		}
+	}
+	sort.Strings(drift.ExtraIndexes)
+	return
+}
+
+// Returns the validator of the collection as stored on the server.
+func collectionValidator(db *mgo.Database, collection string) (validator bson.M, hasCollection bool, err error) {
+
+	var result struct {
+		Cursor struct {
+			FirstBatch []struct {
+				Options struct {
+					Validator bson.M `bson:"validator"`
+				} `bson:"options"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+
+	err = db.Run(bson.D{
+		{Name: "listCollections", Value: 1},
+		{Name: "filter", Value: bson.M{"name": collection}},
+	}, &result)
+	if err != nil || len(result.Cursor.FirstBatch) == 0 {
+		return
+	}
+
+	hasCollection = true
+	validator = result.Cursor.FirstBatch[0].Options.Validator
+	return
+}
+
+// Returns the names of the collections that have a validator on the server.
+func validatedCollections(db *mgo.Database) (collections []string, err error) {
+
+	var result struct {
+		Cursor struct {
+			FirstBatch []struct {
+				Name string `bson:"name"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}
+
+	// the names fit in a single batch, so the cursor is not iterated
+	err = db.Run(bson.D{
+		{Name: "listCollections", Value: 1},
+		{Name: "filter", Value: bson.M{"options.validator": bson.M{"$exists": true}}},
+		{Name: "cursor", Value: bson.M{"batchSize": math.MaxInt32}},
+	}, &result)
+	if err != nil {
+		return
+	}
+
+	for _, collection := range result.Cursor.FirstBatch {
+		collections = append(collections, collection.Name)
+	}
+	return
+}
+
+// Round trips the value through bson so it has the same types
+// as the documents decoded from the server.
+func normalizeBson(value interface{}) (normalized bson.M) {
+
+	raw, marshalErr := bson.Marshal(value)
+	if marshalErr != nil {
+		return
+	}
+	bson.Unmarshal(raw, &normalized)
+	return
+}
+
+func isNamespaceNotFound(err error) bool {
+	queryErr, isQueryErr := err.(*mgo.QueryError)
+	return isQueryErr && queryErr.Code == 26
+}
@@ -0,0 +1,158 @@
+package mongoutil
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"strings"
+)
+
+// prefix of the encrypted values as they are stored
+const encryptedPrefix = "enc:v1:"
+
+// Encrypts the configured fields with AES-GCM before they are written and
+// decrypts them after they are read. Values of any type are encrypted as
+// json, so encrypted fields cannot be used in filters.
+// Example Usage:
+//
+//	provider.Encryption = &mongoutil.FieldEncryption{
+//	    Key:    key, // 16, 24 or 32 bytes
+//	    Fields: map[string][]string{"users": {"email", "phone"}},
+//	}
+type FieldEncryption struct {
+	Key []byte
+	// fetches the key from a key management service if Key is not set
+	KeySource func() ([]byte, error)
+	// encrypted fields per collection
+	Fields map[string][]string
+
+	aead   cipher.AEAD
+	logger Logger
+}
+
+func (e *FieldEncryption) Init() (err *utils.Error) {
+
+	key := e.Key
+	if key == nil && e.KeySource != nil {
+		var sourceErr error
+		if key, sourceErr = e.KeySource(); sourceErr != nil {
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Fetching encryption key failed.",
+			}
+
+			newLogEntry(e.logger).WithFields(LogFields{
+				"reason": sourceErr.Error(),
+			}).Error("Mongo Error: Fetching encryption key failed.")
+			return
+		}
+	}
+
+	block, cipherErr := aes.NewCipher(key)
+	if cipherErr == nil {
+		e.aead, cipherErr = cipher.NewGCM(block)
+	}
+	if cipherErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Encryption key is not valid. Reason: " + cipherErr.Error(),
+		}
+	}
+	return
+}
+
+// Replaces the values of the encrypted fields in the document with their ciphertext.
+func (e *FieldEncryption) encrypt(collection string, document map[string]interface{}) (err *utils.Error) {
+
+	if e == nil {
+		return
+	}
+
+	for _, field := range e.Fields[collection] {
+		value, hasField := document[field]
+		if !hasField || value == nil {
+			continue
+		}
+
+		plaintext, marshalErr := json.Marshal(value)
+		if marshalErr != nil {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Encrypting '" + field + "' failed.",
+			}
+			return
+		}
+
+		nonce := make([]byte, e.aead.NonceSize())
+		if _, randErr := rand.Read(nonce); randErr != nil {
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Encrypting '" + field + "' failed.",
+			}
+			return
+		}
+
+		sealed := e.aead.Seal(nonce, nonce, plaintext, []byte(collection+"."+field))
+		document[field] = encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+	}
+	return
+}
+
+// Replaces the ciphertext of the encrypted fields in the documents with their values.
+func (e *FieldEncryption) decrypt(collection string, documents ...map[string]interface{}) (err *utils.Error) {
+
+	if e == nil {
+		return
+	}
+
+	for _, document := range documents {
+		for _, field := range e.Fields[collection] {
+			stored, isString := document[field].(string)
+			if !isString || !strings.HasPrefix(stored, encryptedPrefix) {
+				continue
+			}
+
+			value, decryptErr := e.open(collection, field, stored)
+			if decryptErr != nil {
+				err = &utils.Error{
+					Code:    http.StatusInternalServerError,
+					Message: "Decrypting '" + field + "' failed.",
+				}
+
+				newLogEntry(e.logger).WithFields(LogFields{
+					"reason":     decryptErr.Error(),
+					"collection": collection,
+					"field":      field,
+				}).Error("Mongo Error: Decrypting field failed.")
+				return
+			}
+			document[field] = value
+		}
+	}
+	return
+}
+
+func (e *FieldEncryption) open(collection, field, stored string) (value interface{}, err error) {
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedPrefix))
+	if err != nil {
+		return
+	}
+	if len(sealed) < e.aead.NonceSize() {
+		err = errors.New("ciphertext is too short")
+		return
+	}
+
+	nonce, ciphertext := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, []byte(collection+"."+field))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(plaintext, &value)
+	return
+}
@@ -0,0 +1,150 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"net"
+	"net/http"
+	"regexp"
+)
+
+// Machine-readable kind of an error. Since utils.Error has no room for it,
+// the kind is derived from the status code, which the provider sets the same
+// way for each kind:
+//
+//	400 bad-filter  the request was rejected, e.g. an invalid filter or patch
+//	404 not-found
+//	405 view-write  the collection is a view
+//	409 conflict    the item changed since it was read, see conflictMessage
+//	409 duplicate   any other 409, e.g. a duplicate key
+//	503 read-only   the message is readOnlyMessage
+//	503 connection  any other 503
+//	504 timeout
+type ErrorKind string
+
+const (
+	ErrNotFound   ErrorKind = "not-found"
+	ErrDuplicate  ErrorKind = "duplicate"
+	ErrTimeout    ErrorKind = "timeout"
+	ErrBadFilter  ErrorKind = "bad-filter"
+	ErrConnection ErrorKind = "connection"
+	ErrConflict   ErrorKind = "conflict"
+	ErrReadOnly   ErrorKind = "read-only"
+	ErrViewWrite  ErrorKind = "view-write"
+)
+
+var kindCodes = map[ErrorKind]int{
+	ErrNotFound:   http.StatusNotFound,
+	ErrDuplicate:  http.StatusConflict,
+	ErrTimeout:    http.StatusGatewayTimeout,
+	ErrBadFilter:  http.StatusBadRequest,
+	ErrConnection: http.StatusServiceUnavailable,
+	ErrConflict:   http.StatusConflict,
+	ErrReadOnly:   http.StatusServiceUnavailable,
+	ErrViewWrite:  http.StatusMethodNotAllowed,
+}
+
+const readOnlyMessage = "Database is in read-only mode."
+
+// the messages of the conflicts end with one of these, which tells them from the duplicates
+var conflictMessage = regexp.MustCompile(`(is not at version -?\d+|doesn't match the conditions of the change|ended before it was acknowledged)\.$`)
+
+func newError(kind ErrorKind, message string) *utils.Error {
+	return &utils.Error{
+		Code:    kindCodes[kind],
+		Message: message,
+	}
+}
+
+// Returns the kind of an error returned by the provider. Returns an
+// empty kind for the status codes that are not classified.
+// Example Usage:
+// if mongoutil.KindOf(err) == mongoutil.ErrDuplicate { ... }
+func KindOf(err *utils.Error) ErrorKind {
+
+	if err == nil {
+		return ""
+	}
+
+	switch err.Code {
+	case http.StatusBadRequest:
+		return ErrBadFilter
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrViewWrite
+	case http.StatusConflict:
+		if conflictMessage.MatchString(err.Message) {
+			return ErrConflict
+		}
+		return ErrDuplicate
+	case http.StatusServiceUnavailable:
+		if err.Message == readOnlyMessage {
+			return ErrReadOnly
+		}
+		return ErrConnection
+	case http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	return ""
+}
+
+// Converts an error returned by the driver to a utils.Error with the
+// kind and status code matching the cause.
+func driverError(driverErr error, message string) *utils.Error {
+
+	switch {
+	case driverErr == mgo.ErrNotFound:
+		return newError(ErrNotFound, message)
+	case mgo.IsDup(driverErr):
+		return newError(ErrDuplicate, message)
+	case isTimeoutError(driverErr):
+		return newError(ErrTimeout, message)
+	case isConnectionError(driverErr):
+		return newError(ErrConnection, message)
+	case isBadFilterError(driverErr):
+		return newError(ErrBadFilter, message)
+	case isViewWriteError(driverErr):
+		return newError(ErrViewWrite, message)
+	}
+	return &utils.Error{
+		Code:    http.StatusInternalServerError,
+		Message: message,
+	}
+}
+
+func isTimeoutError(err error) bool {
+
+	if netErr, isNetErr := err.(net.Error); isNetErr && netErr.Timeout() {
+		return true
+	}
+
+	// 50: operation exceeded time limit
+	queryErr, isQueryErr := err.(*mgo.QueryError)
+	return isQueryErr && queryErr.Code == 50
+}
+
+func isBadFilterError(err error) bool {
+
+	// 2: bad value, 9: failed to parse, 40324: unrecognized pipeline stage
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return e.Code == 2 || e.Code == 9 || e.Code == 40324
+	case *mgo.LastError:
+		return e.Code == 2 || e.Code == 9
+	}
+	return false
+}
+
+// Returns true if the error is caused by writing to a view.
+func isViewWriteError(err error) bool {
+
+	// 166: command not supported on view
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return e.Code == 166
+	case *mgo.LastError:
+		return e.Code == 166
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strconv"
+	"time"
+)
+
+// collection that keeps the events of all the streams
+var EventCollection = "events"
+
+// expected version that appends the event regardless of the version of the stream
+const AnyVersion int64 = -1
+
+// An event appended to a stream. Sequences of a stream start from 1 and have no gaps.
+type StreamEvent struct {
+	// generated for each append, to tell whether a failed attempt inserted the event
+	ID         bson.ObjectId          `bson:"_id,omitempty" json:"-"`
+	Stream     string                 `bson:"stream" json:"stream"`
+	Sequence   int64                  `bson:"sequence" json:"sequence"`
+	Data       map[string]interface{} `bson:"data" json:"data"`
+	AppendedAt time.Time              `bson:"appendedAt" json:"appendedAt"`
+}
+
+// Appends the event to the stream and returns its sequence. The expected
+// version is the sequence of the last event the caller has seen, 0 for a new
+// stream. Returns 409 if another event was appended after it. AnyVersion
+// appends the event after the last one.
+// Example Usage:
+//
+//	sequence, err := provider.AppendEvent("order-"+id, map[string]interface{}{"type": "shipped"}, version)
+//	if mongoutil.KindOf(err) == mongoutil.ErrConflict {
+//	    // reload the stream and decide again
+//	}
+func (ma DataProvider) AppendEvent(stream string, event map[string]interface{}, expectedVersion int64) (sequence int64, err *utils.Error) {
+
+	op, err := ma.begin("appendEvent", EventCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(EventCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(EventCollection)
+
+	// the unique index rejects the concurrent appends at the same sequence
+	if err = ma.ensureIndexOnce(connection, mgo.Index{Key: []string{"stream", "sequence"}, Unique: true}); err != nil {
+		return
+	}
+
+	for {
+		version := expectedVersion
+		if version == AnyVersion {
+			if version, err = ma.streamVersion(sessionCopy, stream); err != nil {
+				return
+			}
+		}
+
+		stored := StreamEvent{
+			ID:         bson.NewObjectId(),
+			Stream:     stream,
+			Sequence:   version + 1,
+			Data:       event,
+			AppendedAt: time.Now(),
+		}
+		// a duplicate after a failed attempt may be the event inserted by that attempt
+		attempt := 0
+		insertErr := ma.retry(sessionCopy, ma.attempts(EventCollection), func() (err error) {
+			attempt++
+			err = connection.Insert(stored)
+			if attempt > 1 && mgo.IsDup(err) && insertApplied(connection, stored.ID) {
+				err = nil
+			}
+			return
+		})
+		if insertErr == nil {
+			op.countWritten(event)
+			sequence = stored.Sequence
+			return
+		}
+
+		if !mgo.IsDup(insertErr) {
+			err = driverError(insertErr, "Appending event to '"+stream+"' failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason": insertErr.Error(),
+				"stream": stream,
+			}).Error("Mongo Error: Appending event failed.")
+			return
+		}
+
+		// another event took the sequence, which is only a conflict if the caller expected a version
+		if expectedVersion != AnyVersion {
+			err = newError(ErrConflict, "Stream '"+stream+"' is not at version "+strconv.FormatInt(expectedVersion, 10)+".")
+			return
+		}
+	}
+}
+
+// Returns the sequence of the last event of the stream, 0 if it has no events.
+func (ma DataProvider) streamVersion(session *mgo.Session, stream string) (version int64, err *utils.Error) {
+
+	connection := session.DB(ma.Database).C(EventCollection)
+
+	var last StreamEvent
+	findErr := ma.retry(session, ma.attempts(EventCollection), func() error {
+		return connection.Find(bson.M{"stream": stream}).Sort("-sequence").Select(bson.M{"sequence": 1}).One(&last)
+	})
+	if findErr == mgo.ErrNotFound {
+		return
+	}
+	if findErr != nil {
+		err = driverError(findErr, "Reading version of '"+stream+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": findErr.Error(),
+			"stream": stream,
+		}).Error("Mongo Error: Reading stream version failed.")
+		return
+	}
+	version = last.Sequence
+	return
+}
+
+// Returns the events of the stream starting from the sequence, in order.
+// The sequence of the last event is the version to append the next event with.
+// Example Usage:
+// events, err := provider.ReadStream("order-"+id, 1)
+func (ma DataProvider) ReadStream(stream string, fromSequence int64) (events []StreamEvent, err *utils.Error) {
+
+	op, err := ma.begin("readStream", EventCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(EventCollection, true, 1*time.Second, 5*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(EventCollection)
+
+	events = make([]StreamEvent, 0)
+	readErr := ma.retry(sessionCopy, ma.attempts(EventCollection), func() error {
+		return connection.Find(bson.M{"stream": stream, "sequence": bson.M{"$gte": fromSequence}}).Sort("sequence").All(&events)
+	})
+	if readErr != nil {
+		err = driverError(readErr, "Reading stream '"+stream+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": readErr.Error(),
+			"stream": stream,
+		}).Error("Mongo Error: Reading stream failed.")
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Returns whether a document matching the filter exists. Only the id of
+// a single document is fetched, so it is cheaper than a query.
+// Example Usage:
+// taken, err := provider.Exists("users", map[string]interface{}{"email": email})
+//
+func (ma DataProvider) Exists(collection string, filter map[string]interface{}) (exists bool, err *utils.Error) {
+
+	op, err := ma.begin("exists", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, true, 1 * time.Second, 1 * time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
+
+	op.shape = fingerprint(filter)
+	scoped, err := ma.scopedFilter(collection, filter)
+	if err != nil {
+		return
+	}
+	var where interface{}
+	if len(scoped) > 0 {
+		where = scoped
+	}
+
+	var item bson.M
+	findErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+		return connection.Find(ma.excludeDeleted(collection, where)).Select(bson.M{ID: 1}).Limit(1).One(&item)
+	})
+
+	if findErr == mgo.ErrNotFound {
+		return
+	}
+	if findErr != nil {
+		err = driverError(findErr, "Checking '"+collection+"' items failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     findErr.Error(),
+			"collection": collection,
+			"shape":      op.shape,
+		}).Error("Mongo Error: Checking existence failed.")
+		return
+	}
+
+	exists = true
+	return
+}
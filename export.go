@@ -0,0 +1,154 @@
+package mongoutil
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// formats of Export
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// Writes the documents matching the filter to w as newline delimited json or
+// csv. The documents are read from a cursor one by one, so exports of any size
+// don't have to fit in memory. If columns are given only these fields are
+// exported, otherwise csv exports use the fields of the first document.
+// The response contains the number of "exported" documents.
+// Example Usage:
+// provider.Export("orders", map[string]interface{}{"status": "paid"}, mongoutil.FormatCSV, w, "_id", "amount")
+func (ma DataProvider) Export(collection string, filter map[string]interface{}, format string, w io.Writer, columns ...string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("export", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if format != FormatNDJSON && format != FormatCSV {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Export format must be '" + FormatNDJSON + "' or '" + FormatCSV + "'.",
+		}
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(5 * time.Second)
+	sessionCopy.SetSocketTimeout(60 * time.Second)
+
+	op.shape = fingerprint(filter)
+	scoped, err := ma.scopedFilter(collection, filter)
+	if err != nil {
+		return
+	}
+	var where interface{}
+	if len(scoped) > 0 {
+		where = scoped
+	}
+
+	query := sessionCopy.DB(ma.Database).C(collection).Find(ma.excludeDeleted(collection, where))
+	if len(columns) > 0 {
+		projection := bson.M{}
+		for _, column := range columns {
+			projection[column] = 1
+		}
+		query = query.Select(projection)
+	}
+	iter := query.Iter()
+
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(w)
+	exported := 0
+
+	document := make(map[string]interface{})
+	for iter.Next(&document) {
+		if err = ma.Encryption.decrypt(collection, document); err != nil {
+			iter.Close()
+			return
+		}
+		ma.hexIds(collection, document)
+		ma.stringFields(collection, document)
+
+		var writeErr error
+		if format == FormatNDJSON {
+			writeErr = encoder.Encode(document)
+		} else {
+			if csvWriter == nil {
+				csvWriter = csv.NewWriter(w)
+				if len(columns) == 0 {
+					for k := range document {
+						columns = append(columns, k)
+					}
+					sort.Strings(columns)
+				}
+				writeErr = csvWriter.Write(columns)
+			}
+			if writeErr == nil {
+				writeErr = csvWriter.Write(csvRow(document, columns))
+			}
+		}
+
+		if writeErr != nil {
+			iter.Close()
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Writing export failed. Reason: " + writeErr.Error(),
+			}
+			return
+		}
+
+		op.countRead(document)
+		exported++
+		document = make(map[string]interface{})
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+
+	if iterErr := iter.Close(); iterErr != nil {
+		err = driverError(iterErr, "Exporting '"+collection+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     iterErr.Error(),
+			"collection": collection,
+			"exported":   exported,
+		}).Error("Mongo Error: Exporting items failed.")
+		return
+	}
+
+	response = map[string]interface{}{
+		"exported": exported,
+	}
+	return
+}
+
+// Returns the values of the columns, nested values are written as json.
+func csvRow(document map[string]interface{}, columns []string) []string {
+
+	row := make([]string, len(columns))
+	for i, column := range columns {
+		switch value := document[column].(type) {
+		case nil:
+		case string:
+			row[i] = value
+		case map[string]interface{}, bson.M, []interface{}:
+			encoded, _ := json.Marshal(value)
+			row[i] = string(encoded)
+		default:
+			row[i] = fmt.Sprint(value)
+		}
+	}
+	return row
+}
@@ -0,0 +1,282 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"strings"
+)
+
+// Extras of the FieldAccess interceptor.
+type FieldAccessOptions struct {
+	// key of the requestscope that holds the role, or the roles as []string,
+	// of the requester. defaults to "role"
+	RoleKey string
+	// fields mapped to the roles that can read them. nested fields are given
+	// with dots and are removed from every element of the arrays on their path
+	Fields map[string][]string
+	// fields of the other collections mapped to the roles that can read them,
+	// keyed by collection. they are removed from the documents of those
+	// collections nested by the include parameter
+	CollectionFields map[string]map[string][]string
+}
+
+func (o FieldAccessOptions) roleKey() string {
+	if o.RoleKey == "" {
+		return "role"
+	}
+	return o.RoleKey
+}
+
+// Returns the paths of the fields that none of the roles in the requestscope can read.
+func (o FieldAccessOptions) hiddenFields(rs requestscope.RequestScope, fields map[string][]string) (hidden [][]string) {
+
+	roles := scopeRoles(rs, o.roleKey())
+	for field, readers := range fields {
+		canRead := false
+		for _, role := range roles {
+			if containsString(readers, role) {
+				canRead = true
+				break
+			}
+		}
+		if !canRead {
+			hidden = append(hidden, strings.Split(field, "."))
+		}
+	}
+	return
+}
+
+// Returns the role, or the roles given as []string, in the requestscope.
+func scopeRoles(rs requestscope.RequestScope, key string) []string {
+	switch role := rs.Get(key).(type) {
+	case string:
+		return []string{role}
+	case []string:
+		return role
+	}
+	return nil
+}
+
+// Removes the fields that the role of the requester cannot read from the
+// responses. Applies to the documents returned by Get, to each document in the
+// results of Query and GetMany, and to the documents nested by the include
+// parameter. Queries that filter, sort or aggregate by the hidden fields, or
+// ask for their distinct values, are rejected with 403 since they would reveal
+// the values. Must be added to GET requests after the execution, and can also
+// be added before the execution to reject such queries before they are run.
+// Example Usage:
+//
+//	core.Interceptors.Add("/users", methods.Get, interceptors.AFTER_EXEC, mongoutil.FieldAccess, mongoutil.FieldAccessOptions{
+//	    Fields: map[string][]string{"email": {"admin"}, "address.phone": {"admin", "support"}},
+//	})
+//	core.Interceptors.Add("/users/{id}", methods.Get, interceptors.AFTER_EXEC, mongoutil.FieldAccess, ...)
+func FieldAccess(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	options, _ := extras.(FieldAccessOptions)
+	collection, id := splitResource(req.Res)
+
+	hidden := options.hiddenFields(rs, options.Fields)
+	if id == "" {
+		if err = checkHiddenReferences(req.Parameters, hidden); err != nil {
+			return
+		}
+	}
+
+	// the fields of the included documents are hidden by their paths in the document
+	include, _, _ := extractStringParameter(req.Parameters, "include")
+	if include != "" {
+		provider, _ := db.(*DataProvider)
+		hidden = append(hidden, options.includedHiddenFields(rs, provider, collection, parseIncludes(strings.Split(include, ",")), nil)...)
+	}
+
+	if len(hidden) == 0 || res.Body == nil {
+		return
+	}
+
+	editedRes = res
+	if id != "" {
+		editedRes.Body = withoutFields(res.Body, hidden).(map[string]interface{})
+		return
+	}
+
+	body := make(map[string]interface{}, len(res.Body))
+	for k, v := range res.Body {
+		body[k] = v
+	}
+	switch results := res.Body[List].(type) {
+	case []map[string]interface{}:
+		edited := make([]map[string]interface{}, len(results))
+		for i, result := range results {
+			edited[i] = withoutFields(result, hidden).(map[string]interface{})
+		}
+		body[List] = edited
+	case []interface{}, map[string]interface{}:
+		// the results of GetMany are keyed by id
+		body[List] = withoutFieldsOfEach(results, hidden)
+	}
+	editedRes.Body = body
+	return
+}
+
+// Returns the paths of the hidden fields of the included documents, prefixed
+// with the names of the relations they are nested in. The relations can only
+// be resolved through the provider.
+func (o FieldAccessOptions) includedHiddenFields(rs requestscope.RequestScope, provider *DataProvider, collection string, tree includeTree, prefix []string) (hidden [][]string) {
+
+	if provider == nil {
+		return
+	}
+	for name, children := range tree {
+		relation, hasRelation := provider.relation(collection, name)
+		if !hasRelation {
+			continue
+		}
+		path := append(append([]string{}, prefix...), name)
+		for _, field := range o.hiddenFields(rs, o.CollectionFields[relation.Collection]) {
+			hidden = append(hidden, append(append([]string{}, path...), field...))
+		}
+		hidden = append(hidden, o.includedHiddenFields(rs, provider, relation.Collection, children, path)...)
+	}
+	return
+}
+
+// Returns 403 if the query parameters refer to a hidden field, or to a document
+// containing one, in the filters, the sort, the aggregation or the distinct field.
+func checkHiddenReferences(parameters map[string][]string, hidden [][]string) (err *utils.Error) {
+
+	if len(hidden) == 0 {
+		return
+	}
+
+	var fields []string
+	mode, _, _ := extractStringParameter(parameters, "mode")
+	if mode == ModeDistinct {
+		field, _, _ := extractStringParameter(parameters, "field")
+		fields = append(fields, field)
+	}
+	if sort, hasSort, _ := extractStringParameter(parameters, "sort"); hasSort {
+		fields = append(fields, strings.TrimLeft(sort, "+-"))
+	}
+	for _, key := range []string{"where", "or", "nor", "aggregate"} {
+		if value, hasParam, _ := extractJsonParameter(parameters, key); hasParam {
+			fields = append(fields, referencedFields(value, "")...)
+		}
+	}
+	for key := range parameters {
+		if match := friendlyParameter.FindStringSubmatch(key); match != nil {
+			fields = append(fields, match[1])
+		}
+	}
+
+	for _, field := range fields {
+		for _, path := range hidden {
+			hiddenField := strings.Join(path, ".")
+			if field == hiddenField || strings.HasPrefix(field, hiddenField+".") || strings.HasPrefix(hiddenField, field+".") {
+				err = &utils.Error{
+					Code:    http.StatusForbidden,
+					Message: "Requester cannot query by '" + field + "'.",
+				}
+				return
+			}
+		}
+	}
+	return
+}
+
+// Returns the fields that the filter or the pipeline refers to, which are the
+// keys that are not operators and the "$field" path strings. It also returns
+// the names of the computed fields, so it errs on the side of rejecting.
+func referencedFields(value interface{}, prefix string) (fields []string) {
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, element := range v {
+			fields = append(fields, referencedFields(element, prefix)...)
+		}
+	case map[string]interface{}:
+		for key, nested := range v {
+			if strings.HasPrefix(key, "$") {
+				fields = append(fields, referencedFields(nested, prefix)...)
+				continue
+			}
+			fields = append(fields, prefix+key)
+			// the fields in $elemMatch are relative to the array
+			fields = append(fields, referencedFields(nested, prefix+key+".")...)
+		}
+	case string:
+		if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "$$") {
+			fields = append(fields, v[1:])
+		}
+	}
+	return
+}
+
+// Returns a copy of the document without the fields. Only the parts of the
+// document on the paths of the fields are copied.
+func withoutFields(document interface{}, fields [][]string) interface{} {
+	for _, path := range fields {
+		document = withoutField(document, path)
+	}
+	return document
+}
+
+func withoutField(value interface{}, path []string) interface{} {
+
+	switch v := value.(type) {
+	case []interface{}:
+		edited := make([]interface{}, len(v))
+		for i, element := range v {
+			edited[i] = withoutField(element, path)
+		}
+		return edited
+	case []map[string]interface{}:
+		edited := make([]map[string]interface{}, len(v))
+		for i, element := range v {
+			edited[i] = withoutField(element, path).(map[string]interface{})
+		}
+		return edited
+	}
+
+	document, isObject := asObject(value)
+	if !isObject {
+		return value
+	}
+	nested, hasField := document[path[0]]
+	if !hasField {
+		return value
+	}
+
+	edited := make(map[string]interface{}, len(document))
+	for k, v := range document {
+		edited[k] = v
+	}
+	if len(path) == 1 {
+		delete(edited, path[0])
+	} else {
+		edited[path[0]] = withoutField(nested, path[1:])
+	}
+	return edited
+}
+
+// Removes the fields from each element of the list, or each value of the map.
+func withoutFieldsOfEach(results interface{}, fields [][]string) interface{} {
+
+	switch v := results.(type) {
+	case []interface{}:
+		edited := make([]interface{}, len(v))
+		for i, result := range v {
+			edited[i] = withoutFields(result, fields)
+		}
+		return edited
+	case map[string]interface{}:
+		edited := make(map[string]interface{}, len(v))
+		for id, result := range v {
+			edited[id] = withoutFields(result, fields)
+		}
+		return edited
+	}
+	return results
+}
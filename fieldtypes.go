@@ -0,0 +1,248 @@
+package mongoutil
+
+import (
+	"encoding/base64"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// types of the fields declared in FieldTypes
+type FieldType string
+
+const (
+	TypeString FieldType = "string"
+	TypeInt    FieldType = "int"
+	TypeFloat  FieldType = "float"
+	TypeBool   FieldType = "bool"
+	// RFC 3339 strings are stored as dates
+	TypeDate FieldType = "date"
+	// hex strings are stored as ObjectIds, for the references to the collections in ObjectIds
+	TypeObjectId FieldType = "objectId"
+	// numbers and numeric strings are stored as Decimal128 without the rounding
+	// of float64, e.g. for the amounts of money. the responses have them as strings
+	TypeDecimal FieldType = "decimal"
+	// base64 strings are stored as binary data, for the small blobs like thumbnails
+	// and keys that don't need GridFS. the responses have them as base64 strings
+	TypeBinary FieldType = "binary"
+)
+
+// Converts the values of the declared fields of the document to their types,
+// e.g. "10" to 10 for an int field. Returns 400 listing all the fields whose
+// values cannot be converted. Null values are kept.
+func (ma DataProvider) coerceFields(collection string, document map[string]interface{}) (err *utils.Error) {
+
+	var invalid []string
+	for field, fieldType := range ma.FieldTypes[collection] {
+		parent, key, found := fieldParent(document, field)
+		if !found || parent[key] == nil {
+			continue
+		}
+		value, ok := coerceValue(fieldType, parent[key])
+		if !ok {
+			invalid = append(invalid, "'"+field+"' must be "+string(fieldType))
+			continue
+		}
+		parent[key] = value
+	}
+
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Input is not valid. " + strings.Join(invalid, "; ") + ".",
+		}
+	}
+	return
+}
+
+// Returns the document holding the dotted field and the key of the field in it.
+// Updates may contain the dotted field itself.
+func fieldParent(document map[string]interface{}, field string) (parent map[string]interface{}, key string, found bool) {
+
+	if _, hasField := document[field]; hasField {
+		return document, field, true
+	}
+
+	segments := strings.Split(field, ".")
+	parent = document
+	for _, segment := range segments[:len(segments)-1] {
+		if parent, found = asObject(parent[segment]); !found {
+			return
+		}
+	}
+	key = segments[len(segments)-1]
+	_, found = parent[key]
+	return
+}
+
+func coerceValue(fieldType FieldType, value interface{}) (coerced interface{}, ok bool) {
+
+	switch fieldType {
+	case TypeString:
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case int, int32, int64:
+			return strconv.FormatInt(int64(toFloat(v)), 10), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+	case TypeInt:
+		switch v := value.(type) {
+		case int:
+			return int64(v), true
+		case int32:
+			return int64(v), true
+		case int64:
+			return v, true
+		case float64:
+			if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+				return int64(v), true
+			}
+		case string:
+			if i, parseErr := strconv.ParseInt(strings.TrimSpace(v), 10, 64); parseErr == nil {
+				return i, true
+			}
+		}
+	case TypeFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case int, int32, int64:
+			return toFloat(v), true
+		case string:
+			if f, parseErr := strconv.ParseFloat(strings.TrimSpace(v), 64); parseErr == nil {
+				return f, true
+			}
+		}
+	case TypeBool:
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			if b, parseErr := strconv.ParseBool(strings.TrimSpace(v)); parseErr == nil {
+				return b, true
+			}
+		}
+	case TypeDate:
+		switch v := value.(type) {
+		case time.Time:
+			return v, true
+		case string:
+			if t, parseErr := time.Parse(time.RFC3339Nano, v); parseErr == nil {
+				return t, true
+			}
+		}
+	case TypeDecimal:
+		switch v := value.(type) {
+		case bson.Decimal128:
+			return v, true
+		case float64:
+			if decimal, parseErr := bson.ParseDecimal128(strconv.FormatFloat(v, 'f', -1, 64)); parseErr == nil {
+				return decimal, true
+			}
+		case int, int32, int64:
+			if decimal, parseErr := bson.ParseDecimal128(strconv.FormatInt(int64(toFloat(v)), 10)); parseErr == nil {
+				return decimal, true
+			}
+		case string:
+			if decimal, parseErr := bson.ParseDecimal128(strings.TrimSpace(v)); parseErr == nil {
+				return decimal, true
+			}
+		}
+	case TypeBinary:
+		switch v := value.(type) {
+		case []byte:
+			return v, true
+		case bson.Binary:
+			return v.Data, true
+		case string:
+			if data, decodeErr := base64.StdEncoding.DecodeString(v); decodeErr == nil {
+				return data, true
+			}
+		}
+	case TypeObjectId:
+		switch v := value.(type) {
+		case bson.ObjectId:
+			return v, true
+		case string:
+			if bson.IsObjectIdHex(v) {
+				return bson.ObjectIdHex(v), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Converts the decimal and binary fields of the documents to strings, since
+// JSON numbers would lose the precision of the decimals in the clients and
+// json.Marshal encodes Decimal128 as {}. The embedded documents on the paths
+// of the fields are copied, so the documents sharing them are not changed.
+func (ma DataProvider) stringFields(collection string, items ...map[string]interface{}) {
+
+	for field, fieldType := range ma.FieldTypes[collection] {
+		if fieldType != TypeDecimal && fieldType != TypeBinary {
+			continue
+		}
+		for _, item := range items {
+			switch v := fieldValue(item, field).(type) {
+			case bson.Decimal128:
+				setPath(item, field, v.String())
+			case []byte:
+				setPath(item, field, base64.StdEncoding.EncodeToString(v))
+			case bson.Binary:
+				setPath(item, field, base64.StdEncoding.EncodeToString(v.Data))
+			}
+		}
+	}
+}
+
+// Returns a copy of the document with its decimal and binary fields as strings.
+func (ma DataProvider) stringDocument(collection string, document map[string]interface{}) map[string]interface{} {
+	if document == nil {
+		return nil
+	}
+	document = copyDocument(document)
+	ma.stringFields(collection, document)
+	return document
+}
+
+// Returns the value of the dot-notation path, or nil if it doesn't exist.
+func fieldValue(document map[string]interface{}, path string) interface{} {
+
+	var value interface{} = document
+	for _, segment := range strings.Split(path, ".") {
+		embedded, isObject := asObject(value)
+		if !isObject {
+			return nil
+		}
+		value = embedded[segment]
+	}
+	return value
+}
+
+// Returns 500 if a declared type is not known.
+func (ma DataProvider) checkFieldTypes() (err *utils.Error) {
+	for collection, fields := range ma.FieldTypes {
+		for field, fieldType := range fields {
+			switch fieldType {
+			case TypeString, TypeInt, TypeFloat, TypeBool, TypeDate, TypeObjectId, TypeDecimal, TypeBinary:
+			default:
+				err = &utils.Error{
+					Code:    http.StatusInternalServerError,
+					Message: "Type '" + string(fieldType) + "' of '" + collection + "." + field + "' is not valid.",
+				}
+				return
+			}
+		}
+	}
+	return
+}
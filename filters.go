@@ -0,0 +1,214 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// operators of the flattened filter parameters
+var friendlyOperators = map[string]string{
+	"eq":  "$eq",
+	"ne":  "$ne",
+	"gt":  "$gt",
+	"gte": "$gte",
+	"lt":  "$lt",
+	"lte": "$lte",
+	"in":  "$in",
+	"nin": "$nin",
+	// exists=false matches only the missing fields, null=true only the null fields
+	"exists": "$exists",
+	"null":   "$type",
+}
+
+// matches the flattened filter parameters, e.g. price[gt]
+var friendlyParameter = regexp.MustCompile(`^([^\[\]$][^\[\]]*)\[([a-z]+)\]$`)
+
+// Builds a where filter from the flattened filter parameters, so simple clients
+// can filter without writing JSON. The values of in and nin are separated with
+// commas. Numbers and booleans are converted unless the value is quoted.
+// Example Usage:
+// ?price[gte]=10&price[lt]=20&status[in]=active,pending&name[eq]="007"
+// is the same as
+// ?where={"price":{"$gte":10,"$lt":20},"status":{"$in":["active","pending"]},"name":{"$eq":"007"}}
+func friendlyFilter(parameters map[string][]string) (filter map[string]interface{}, hasFilter bool, err *utils.Error) {
+
+	keys := make([]string, 0)
+	for key := range parameters {
+		if friendlyParameter.MatchString(key) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+
+	filter = make(map[string]interface{})
+	for _, key := range keys {
+		match := friendlyParameter.FindStringSubmatch(key)
+		field, name := match[1], match[2]
+
+		operator, isOperator := friendlyOperators[name]
+		if !isOperator {
+			err = newError(ErrBadFilter, "Filter operator '"+name+"' of '"+field+"' is not supported.")
+			return
+		}
+
+		raw := ""
+		if len(parameters[key]) > 0 {
+			raw = parameters[key][0]
+		}
+
+		var value interface{}
+		if operator == "$in" || operator == "$nin" {
+			values := make([]interface{}, 0)
+			for _, v := range strings.Split(raw, ",") {
+				values = append(values, friendlyValue(v))
+			}
+			value = values
+		} else if operator == "$type" {
+			if value, err = nullCondition(field, raw); err != nil {
+				return
+			}
+		} else {
+			value = friendlyValue(raw)
+		}
+
+		conditions, _ := filter[field].(map[string]interface{})
+		if conditions == nil {
+			conditions = make(map[string]interface{})
+			filter[field] = conditions
+		}
+		conditions[operator] = value
+	}
+	return filter, true, nil
+}
+
+// Returns the type of the null parameter, which can only be null=true.
+func nullCondition(field, raw string) (value interface{}, err *utils.Error) {
+	if raw != "true" {
+		err = newError(ErrBadFilter, "Filter operator 'null' of '"+field+"' can only be true.")
+		return
+	}
+	return "null", nil
+}
+
+// Converts the value of a flattened filter parameter to the type it looks like.
+func friendlyValue(raw string) interface{} {
+
+	if len(raw) >= 2 && strings.HasPrefix(raw, "\"") && strings.HasSuffix(raw, "\"") {
+		return raw[1 : len(raw)-1]
+	}
+	switch raw {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+
+	// values that change when formatted back, e.g. "007", are kept as strings
+	if number, parseErr := strconv.ParseFloat(raw, 64); parseErr == nil && strconv.FormatFloat(number, 'f', -1, 64) == raw {
+		return number
+	}
+	return raw
+}
+
+// Combines the where parameter with the filter built from the flattened parameters.
+func combineFilters(where interface{}, filter map[string]interface{}) interface{} {
+	if where == nil {
+		return filter
+	}
+	return map[string]interface{}{"$and": []interface{}{where, filter}}
+}
+
+// limits of the boolean operators in the filters if they are not configured
+const (
+	DefaultMaxFilterDepth   = 4
+	DefaultMaxFilterClauses = 50
+)
+
+var logicalOperators = map[string]bool{
+	"$and": true,
+	"$or":  true,
+	"$nor": true,
+}
+
+// Builds the filter of the or and nor parameters, which are JSON lists of
+// filters, so OR conditions can be combined with the flattened parameters.
+// Example Usage:
+// ?or=[{"status":"A"},{"status":"B"}]&date[gt]=1600000000
+func logicalFilter(parameters map[string][]string) (filter map[string]interface{}, hasFilter bool, err *utils.Error) {
+
+	filter = make(map[string]interface{})
+	for _, key := range []string{"or", "nor"} {
+		value, hasParam, paramErr := extractJsonParameter(parameters, key)
+		if paramErr != nil {
+			err = paramErr
+			return
+		}
+		if hasParam {
+			filter["$"+key] = value
+		}
+	}
+	return filter, len(filter) > 0, nil
+}
+
+// Returns 400 if the $and, $or and $nor operators of the filter are malformed,
+// nested deeper than MaxFilterDepth or have more than MaxFilterClauses clauses
+// in total, since large boolean filters are expensive to plan and run.
+func (ma DataProvider) checkLogical(filter interface{}) (err *utils.Error) {
+
+	maxDepth, maxClauses := ma.MaxFilterDepth, ma.MaxFilterClauses
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxFilterDepth
+	}
+	if maxClauses <= 0 {
+		maxClauses = DefaultMaxFilterClauses
+	}
+
+	clauses := 0
+	var check func(node interface{}, depth int) *utils.Error
+	check = func(node interface{}, depth int) *utils.Error {
+
+		conditions, isMap := node.(map[string]interface{})
+		if !isMap {
+			return nil
+		}
+
+		for key, value := range conditions {
+			if !logicalOperators[key] {
+				continue
+			}
+
+			list, isList := value.([]interface{})
+			if !isList || len(list) == 0 {
+				return newError(ErrBadFilter, "The value of '"+key+"' must be a non-empty list of filters.")
+			}
+			if depth+1 > maxDepth {
+				return newError(ErrBadFilter, "Boolean operators cannot be nested deeper than "+strconv.Itoa(maxDepth)+" levels.")
+			}
+			clauses += len(list)
+			if clauses > maxClauses {
+				return newError(ErrBadFilter, "Filters cannot have more than "+strconv.Itoa(maxClauses)+" boolean clauses.")
+			}
+
+			for _, clause := range list {
+				if _, isClauseMap := clause.(map[string]interface{}); !isClauseMap {
+					return newError(ErrBadFilter, "The clauses of '"+key+"' must be filters.")
+				}
+				if clauseErr := check(clause, depth+1); clauseErr != nil {
+					return clauseErr
+				}
+			}
+		}
+		return nil
+	}
+
+	err = check(filter, 0)
+	return
+}
@@ -0,0 +1,84 @@
+package mongoutil
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Returns the shape of a filter or pipeline: the fields and operators
+// are kept and the values are replaced by '?', so the queries that differ
+// only by their literal values have the same fingerprint.
+// Example: {"age": {"$gt": 18}, "name": "x"} -> {age:{$gt:?},name:?}
+func fingerprint(query interface{}) string {
+
+	if query == nil {
+		return ""
+	}
+
+	var builder strings.Builder
+	writeShape(&builder, query)
+	return builder.String()
+}
+
+// Returns a short hash of the shape that can be used as a metric tag.
+func fingerprintHash(shape string) string {
+
+	if shape == "" {
+		return ""
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(shape))
+	return strconv.FormatUint(uint64(hash.Sum32()), 16)
+}
+
+func writeShape(builder *strings.Builder, value interface{}) {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		builder.WriteString("{")
+		for i, k := range keys {
+			if i > 0 {
+				builder.WriteString(",")
+			}
+			builder.WriteString(k)
+			builder.WriteString(":")
+			writeShape(builder, v[k])
+		}
+		builder.WriteString("}")
+
+	case []interface{}:
+		// lists of values ($in, $nin...) have the same shape regardless of their length
+		hasDocuments := false
+		for _, item := range v {
+			if _, isMap := item.(map[string]interface{}); isMap {
+				hasDocuments = true
+				break
+			}
+		}
+		if !hasDocuments {
+			builder.WriteString("[?]")
+			return
+		}
+
+		builder.WriteString("[")
+		for i, item := range v {
+			if i > 0 {
+				builder.WriteString(",")
+			}
+			writeShape(builder, item)
+		}
+		builder.WriteString("]")
+
+	default:
+		builder.WriteString("?")
+	}
+}
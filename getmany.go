@@ -0,0 +1,76 @@
+package mongoutil
+
+import (
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// field of the GetMany response listing the ids that were not found
+const Missing = "missing"
+
+// Gets the documents with the given ids in a single query. The documents are
+// returned keyed by id in "results" and the ids that were not found in "missing".
+func (ma DataProvider) GetMany(collection string, ids []string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("getMany", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, true, 1*time.Second, 5*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
+
+	var results []map[string]interface{}
+	if len(ids) > 0 {
+		var filter bson.M
+		if filter, err = ma.scopedFilter(collection, bson.M{ID: bson.M{"$in": ma.storedIds(collection, ids)}}); err != nil {
+			return
+		}
+		scoped := ma.excludeDeleted(collection, filter)
+		getErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			return connection.Find(scoped).All(&results)
+		})
+		if getErr != nil {
+			err = driverError(getErr, "Getting '"+collection+"' items failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason":     getErr.Error(),
+				"collection": collection,
+				"ids":        ids,
+			}).Error("Mongo Error: Getting items failed.")
+			return
+		}
+	}
+
+	op.countRead(results...)
+	ma.repairResults(collection, results)
+	ma.trackAccess(collection, results)
+	if err = ma.Encryption.decrypt(collection, results...); err != nil {
+		return
+	}
+	ma.hexIds(collection, results...)
+	ma.stringFields(collection, results...)
+
+	itemsById := make(map[string]interface{}, len(results))
+	for _, item := range results {
+		itemsById[fmt.Sprint(item[ID])] = item
+	}
+
+	missing := make([]string, 0)
+	for _, id := range ids {
+		if _, found := itemsById[id]; !found {
+			missing = append(missing, id)
+		}
+	}
+
+	response = map[string]interface{}{
+		List:    itemsById,
+		Missing: missing,
+	}
+	return
+}
@@ -3,7 +3,11 @@ module github.com/rihtim/mongoutil
 go 1.15
 
 require (
+	github.com/prometheus/client_golang v1.11.1
 	github.com/rihtim/core v0.1.1
 	github.com/sirupsen/logrus v1.8.1
+	github.com/xeipuuv/gojsonschema v1.2.0
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
 )
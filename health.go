@@ -0,0 +1,80 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"time"
+)
+
+// Pings the server and reports whether the session is connected, the round-trip
+// latency in milliseconds and the replica set status if the server is a member
+// of a replica set. Returns 503 along with the response if the ping fails so
+// the result can be written directly from a /healthz endpoint.
+func (ma DataProvider) Health() (response map[string]interface{}, err *utils.Error) {
+
+	defer ma.recoverPanic(&operation{name: "health"}, &err)
+
+	response = map[string]interface{}{
+		"connected": false,
+	}
+
+	if ma.session == nil {
+		err = &utils.Error{
+			Code:    http.StatusServiceUnavailable,
+			Message: "Database session is not connected.",
+		}
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(1 * time.Second)
+
+	start := time.Now()
+	pingErr := sessionCopy.Ping()
+	latency := time.Since(start)
+
+	if pingErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusServiceUnavailable,
+			Message: "Pinging database failed.",
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason": pingErr.Error(),
+		}).Error("Mongo Error: Ping failed.")
+		return
+	}
+
+	response["connected"] = true
+	response["latency"] = float64(latency) / float64(time.Millisecond)
+	response["servers"] = sessionCopy.LiveServers()
+
+	// standalone servers reject replSetGetStatus, which is not a health problem
+	var status bson.M
+	statusErr := sessionCopy.DB("admin").Run(bson.D{{Name: "replSetGetStatus", Value: 1}}, &status)
+	if statusErr == nil {
+		replicaSet := map[string]interface{}{
+			"name":    status["set"],
+			"myState": status["myState"],
+		}
+
+		if members, isList := status["members"].([]interface{}); isList {
+			memberStates := make([]map[string]interface{}, 0, len(members))
+			for _, m := range members {
+				if member, isMap := m.(bson.M); isMap {
+					memberStates = append(memberStates, map[string]interface{}{
+						"name":   member["name"],
+						"state":  member["stateStr"],
+						"health": member["health"],
+					})
+				}
+			}
+			replicaSet["members"] = memberStates
+		}
+		response["replicaSet"] = replicaSet
+	}
+	return
+}
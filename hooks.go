@@ -0,0 +1,83 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+)
+
+// events that hooks can be registered for
+const (
+	BeforeCreate = "beforeCreate"
+	AfterCreate  = "afterCreate"
+	BeforeUpdate = "beforeUpdate"
+	AfterUpdate  = "afterUpdate"
+	BeforeDelete = "beforeDelete"
+	AfterDelete  = "afterDelete"
+)
+
+// A function run by the provider around the writes to a collection.
+// Before hooks receive the document to be written, which they can modify, and
+// veto the operation by returning an error, which is returned to the caller.
+// After hooks receive the written document and their errors are only logged.
+// The document is nil for BeforeDelete, and for AfterDelete unless the
+// collection has subscribers or cascade rules reading its fields.
+type Hook func(collection string, id interface{}, document map[string]interface{}) *utils.Error
+
+// Registers the hook to be run for the event on the collection. Hooks run in
+// the order they are registered, for the operations made through any copy of
+// the provider, and unlike interceptors they are also run for programmatic access.
+// Example Usage:
+//
+//	provider.RegisterHook(mongoutil.BeforeCreate, "users", func(collection string, id interface{}, user map[string]interface{}) *utils.Error {
+//	    user["email"] = strings.ToLower(user["email"].(string))
+//	    return nil
+//	})
+func (ma *DataProvider) RegisterHook(event, collection string, hook Hook) {
+
+	if ma.state == nil {
+		ma.state = &providerState{session: ma.session}
+	}
+	ma.state.mutex.Lock()
+	defer ma.state.mutex.Unlock()
+
+	if ma.state.hooks == nil {
+		ma.state.hooks = make(map[string]map[string][]Hook)
+	}
+	if ma.state.hooks[event] == nil {
+		ma.state.hooks[event] = make(map[string][]Hook)
+	}
+	ma.state.hooks[event][collection] = append(ma.state.hooks[event][collection], hook)
+}
+
+// Runs the hooks of the event, stopping at the first error.
+func (ma DataProvider) runHooks(event, collection string, id interface{}, document map[string]interface{}) (err *utils.Error) {
+
+	if ma.state == nil {
+		return
+	}
+	ma.state.mutex.RLock()
+	hooks := ma.state.hooks[event][collection]
+	if typeName := ma.documentType(collection, document); typeName != "" {
+		typeHooks := ma.state.hooks[event][typeKey(collection, typeName)]
+		hooks = append(append([]Hook{}, hooks...), typeHooks...)
+	}
+	ma.state.mutex.RUnlock()
+
+	for _, hook := range hooks {
+		if err = hook(collection, id, document); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Runs the after hooks of the event, whose errors cannot fail the finished write.
+func (ma DataProvider) runAfterHooks(event, collection string, id interface{}, document map[string]interface{}) {
+	if err := ma.runHooks(event, collection, id, document); err != nil {
+		ma.logger().WithFields(LogFields{
+			"reason":     err.Message,
+			"event":      event,
+			"collection": collection,
+			"id":         id,
+		}).Error("Mongo Error: Hook failed.")
+	}
+}
@@ -0,0 +1,120 @@
+package mongoutil
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"gopkg.in/mgo.v2/bson"
+	"sync"
+	"time"
+)
+
+// Generates the ids of the created documents that don't have one.
+// Example Usage:
+// provider.IDGenerator = mongoutil.ULIDGenerator{}
+type IDGenerator interface {
+	NewID() string
+}
+
+// Allows using an ordinary function as an IDGenerator.
+// Example Usage:
+// provider.IDGenerator = mongoutil.IDGeneratorFunc(func() string { return "user-" + strconv.Itoa(next()) })
+type IDGeneratorFunc func() string
+
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// Generates hex encoded ObjectIds. This is the default generator.
+type ObjectIdGenerator struct{}
+
+func (ObjectIdGenerator) NewID() string {
+	return bson.NewObjectId().Hex()
+}
+
+// Generates random (version 4) UUIDs in the canonical 36 character form.
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) NewID() string {
+
+	var u [16]byte
+	randomBytes(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+// Generates ULIDs, which sort lexicographically by their creation time.
+// Ids generated in the same millisecond by the same process are monotonic.
+type ULIDGenerator struct{}
+
+// Crockford's base32 alphabet used by ULIDs
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ulidState struct {
+	sync.Mutex
+	lastTime uint64
+	lastRand [10]byte
+}
+
+func (ULIDGenerator) NewID() string {
+
+	var u [16]byte
+	now := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+
+	ulidState.Lock()
+	if now == ulidState.lastTime {
+		// increment the random part so the ids stay ordered within the millisecond
+		for i := len(ulidState.lastRand) - 1; i >= 0; i-- {
+			ulidState.lastRand[i]++
+			if ulidState.lastRand[i] != 0 {
+				break
+			}
+		}
+	} else {
+		ulidState.lastTime = now
+		randomBytes(ulidState.lastRand[:])
+	}
+	copy(u[6:], ulidState.lastRand[:])
+	ulidState.Unlock()
+
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], now)
+	copy(u[:6], timestamp[2:])
+
+	// 128 bits are encoded as 26 characters of 5 bits, the first one having only 3
+	buf := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	for i := 25; i >= 0; i-- {
+		buf[i] = ulidAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(buf)
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic("reading random bytes failed: " + err.Error())
+	}
+}
+
+// Returns an id from the configured generator.
+func (ma DataProvider) newId() string {
+	if ma.IDGenerator == nil {
+		return ObjectIdGenerator{}.NewID()
+	}
+	return ma.IDGenerator.NewID()
+}
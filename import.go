@@ -0,0 +1,202 @@
+package mongoutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options of Import.
+type ImportOptions struct {
+	// replaces the fields of the documents with existing ids instead of failing
+	Upsert bool
+	// number of documents written at once, defaults to 500
+	BatchSize int
+	// called after every batch with the number of lines processed so far
+	Progress func(processed int)
+}
+
+// Error of a single line of an import. Lines start from 1.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// Reads newline delimited json documents from r and writes them in batches.
+// The lines that can't be parsed, validated or written are collected in
+// "errors" of the response instead of stopping the import. The response
+// also contains the number of "written" documents.
+// Example Usage:
+// response, err := provider.Import("products", file, mongoutil.ImportOptions{Upsert: true})
+func (ma DataProvider) Import(collection string, r io.Reader, options ImportOptions) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("import", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(5 * time.Second)
+	sessionCopy.SetSocketTimeout(60 * time.Second)
+	ma.applyCausality(sessionCopy, false)
+	connection := sessionCopy.DB(ma.Database).C(collection)
+	createdAtField, updatedAtField := ma.TimestampFieldNames(collection)
+
+	importErrors := make([]ImportError, 0)
+	written := 0
+	processed := 0
+
+	var batch []map[string]interface{}
+	var batchLines []int
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		n, batchErrors := ma.writeBatch(connection, batch, batchLines, options.Upsert)
+		written += n
+		for _, document := range batch {
+			ma.uncache(collection, fmt.Sprint(hexId(document[ID])))
+		}
+		ma.InvalidateQueries(collection)
+		importErrors = append(importErrors, batchErrors...)
+		op.countWritten(batch...)
+		batch, batchLines = nil, nil
+
+		if options.Progress != nil {
+			options.Progress(processed)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		processed++
+
+		var document map[string]interface{}
+		if parseErr := json.Unmarshal([]byte(text), &document); parseErr != nil {
+			importErrors = append(importErrors, ImportError{Line: line, Message: parseErr.Error()})
+			continue
+		}
+
+		if id, hasId := document[ID]; !hasId || id == "" {
+			document[ID] = ma.newId()
+		}
+		document[ID] = ma.storedId(collection, document[ID])
+		now := ma.now()
+		setField(document, createdAtField, now)
+		setField(document, updatedAtField, now)
+
+		if validateErr := ma.validateSchema(collection, document); validateErr != nil {
+			importErrors = append(importErrors, ImportError{Line: line, Message: validateErr.Message})
+			continue
+		}
+		if encryptErr := ma.Encryption.encrypt(collection, document); encryptErr != nil {
+			importErrors = append(importErrors, ImportError{Line: line, Message: encryptErr.Message})
+			continue
+		}
+
+		batch = append(batch, document)
+		batchLines = append(batchLines, line)
+		if len(batch) >= options.BatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if scanErr := scanner.Err(); scanErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Reading import failed after line " + strconv.Itoa(line) + ". Reason: " + scanErr.Error(),
+		}
+		return
+	}
+
+	response = map[string]interface{}{
+		"written": written,
+		"errors":  importErrors,
+	}
+	return
+}
+
+// Writes the documents with a single bulk operation. Returns the number of
+// documents written and the errors of the documents that failed.
+func (ma DataProvider) writeBatch(connection *mgo.Collection, documents []map[string]interface{}, lines []int, upsert bool) (written int, errors []ImportError) {
+
+	createdAtField, _ := ma.TimestampFieldNames(connection.Name)
+
+	bulk := connection.Bulk()
+	bulk.Unordered()
+	for _, document := range documents {
+		if upsert {
+			fields := make(map[string]interface{}, len(document))
+			for k, v := range document {
+				if k != ID && k != createdAtField {
+					fields[k] = v
+				}
+			}
+			change := bson.M{"$set": fields}
+			if createdAtField != "" {
+				change["$setOnInsert"] = bson.M{createdAtField: document[createdAtField]}
+			}
+			bulk.Upsert(bson.M{ID: document[ID]}, change)
+		} else {
+			bulk.Insert(document)
+		}
+	}
+
+	_, runErr := bulk.Run()
+	if runErr == nil {
+		written = len(documents)
+		return
+	}
+
+	bulkErr, isBulkErr := runErr.(*mgo.BulkError)
+	if !isBulkErr {
+		for _, line := range lines {
+			errors = append(errors, ImportError{Line: line, Message: runErr.Error()})
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     runErr.Error(),
+			"collection": connection.Name,
+		}).Error("Mongo Error: Writing import batch failed.")
+		return
+	}
+
+	failed := make(map[int]bool)
+	for _, c := range bulkErr.Cases() {
+		if c.Index < 0 || c.Index >= len(lines) {
+			continue
+		}
+		failed[c.Index] = true
+		errors = append(errors, ImportError{Line: lines[c.Index], Message: c.Err.Error()})
+	}
+	written = len(documents) - len(failed)
+	return
+}
@@ -0,0 +1,42 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// Adds the delta to the numeric field atomically, which is created if it
+// doesn't exist, and returns its new value in the field of the response.
+// Example Usage:
+// response, err := provider.Increment("products", id, "stock", -1)
+// stock := response["stock"]
+func (ma DataProvider) Increment(collection, id, field string, delta int64) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("increment", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+	if err = ma.checkModifiedField(collection, field); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+
+	after, err := ma.modify(sessionCopy, collection, id, bson.M{"$inc": bson.M{field: delta}}, nil)
+	if err != nil {
+		return
+	}
+
+	op.countWritten(after)
+	response = ma.modifyResponse(collection, after)
+	response[field], _ = lookupField(after, field)
+	return
+}
@@ -2,6 +2,7 @@ package mongoutil
 
 import (
 	"net/http"
+	"strings"
 	"github.com/rihtim/core/utils"
 	"github.com/rihtim/core/requestscope"
 	"github.com/rihtim/core/messages"
@@ -9,23 +10,53 @@ import (
 )
 
 // these fields are generated and maintained by
-// mongoutil provider so having them in input are not allowed
+// mongoutil provider so having them in input are not allowed.
+// the timestamp fields are added per collection by restrictedFieldsOf
 var restrictedFields = []string{
 	ID,
-	CreatedAt,
-	UpdatedAt,
+	LastAccessedAt,
+	DeletedAt,
+}
+
+// Returns the restricted fields of the collection with the timestamp fields
+// as they are named for it.
+func restrictedFieldsOf(db dataprovider.Provider, collection string) []string {
+
+	createdAt, updatedAt := CreatedAt, UpdatedAt
+	if provider, isProvider := db.(*DataProvider); isProvider {
+		createdAt, updatedAt = provider.TimestampFieldNames(collection)
+	}
+	fields := append([]string{}, restrictedFields...)
+	for _, field := range []string{createdAt, updatedAt} {
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
 }
 
 // Checks body of the request. Returns error if the request body
 // contains any restricted fields. Must be added to POST and PUT requests for all paths.
+// Fields given as extras ([]string) are restricted in addition to the generated fields.
+// The size and the nesting of the body are limited by MaxDocumentSize and MaxDocumentDepth
+// of the provider.
 // Example Usage:
 // core.Interceptors.Add(interceptors.AnyPath, methods.Post, interceptors.BEFORE_EXEC, mongoutil.ValidateInput, nil)
 // core.Interceptors.Add(interceptors.AnyPath, methods.Put, interceptors.BEFORE_EXEC, mongoutil.ValidateInput, nil)
+// core.Interceptors.Add("/users/{id}", methods.Put, interceptors.BEFORE_EXEC, mongoutil.ValidateInput, []string{"owner", "role"})
 //
 func ValidateInput(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
 
-	for _, field := range restrictedFields {
-		if _, containsField := req.Body[field]; containsField {
+	collection, _ := splitResource(req.Res)
+	fields := restrictedFieldsOf(db, collection)
+	if extraFields, hasExtraFields := extras.([]string); hasExtraFields {
+		fields = append(fields, extraFields...)
+	}
+
+	for _, field := range fields {
+		// the timestamp fields can be nested, e.g. "meta.created"
+		_, containsNested := storedField(req.Body, field)
+		if _, containsField := req.Body[field]; containsField || containsNested {
 			err = &utils.Error{
 				Code:    http.StatusBadRequest,
 				Message: "Input cannot contain '" + field + "' field.",
@@ -33,5 +64,44 @@ func ValidateInput(rs requestscope.RequestScope, extras interface{}, req, res me
 			return
 		}
 	}
+
+	maxSize, maxDepth := documentLimits(db)
+	err = checkDocumentLimits(req.Body, maxSize, maxDepth)
+	return
+}
+
+// Checks that the request body contains the required fields. Returns 400 listing
+// all the missing fields. Fields with null values are considered missing.
+// The required fields are given as extras, either as []string for the path or
+// as map[string][]string keyed by collection for the paths of many collections.
+// Must be added to POST requests, since updates contain only the changed fields.
+// Example Usage:
+// core.Interceptors.Add("/users", methods.Post, interceptors.BEFORE_EXEC, mongoutil.RequireFields, []string{"name", "email"})
+// core.Interceptors.Add(interceptors.AnyPath, methods.Post, interceptors.BEFORE_EXEC, mongoutil.RequireFields, map[string][]string{"users": {"name"}, "orders": {"items"}})
+//
+func RequireFields(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	var required []string
+	switch fields := extras.(type) {
+	case []string:
+		required = fields
+	case map[string][]string:
+		collection, _ := splitResource(req.Res)
+		required = fields[collection]
+	}
+
+	var missing []string
+	for _, field := range required {
+		if value, containsField := req.Body[field]; !containsField || value == nil {
+			missing = append(missing, field)
+		}
+	}
+
+	if len(missing) > 0 {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Input must contain the fields: '" + strings.Join(missing, "', '") + "'.",
+		}
+	}
 	return
 }
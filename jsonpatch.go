@@ -0,0 +1,273 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An operation of a JSON Patch (RFC 6902). Paths are JSON Pointers, e.g. "/address/city".
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Applies the JSON Patch (RFC 6902) to the document atomically. The add,
+// remove, replace, move and test operations are supported:
+//
+//	add     sets the field, or inserts into the array at the index or at "-"
+//	remove  unsets the field, which must exist. array elements cannot be removed by index
+//	replace sets the field, which must exist
+//	move    renames the field, which must exist. array elements cannot be moved
+//	test    requires the field to be equal to the value
+//
+// The operations are translated into a single update, so two operations cannot
+// change the same path or a path and its parent. Tests are evaluated against the
+// document before the patch, so a test cannot follow an operation changing its
+// path. Numeric segments at the end of a path are taken as array indexes, so
+// numeric keys of embedded objects cannot be patched. Returns 409 if a field
+// doesn't exist or a test fails, in which case none of the operations are applied.
+// The values of the declared FieldTypes are converted, and the patched document
+// is validated if the collection has a schema. BeforeUpdate hooks are not run
+// since there is no document to give them.
+// Example Usage:
+//
+//	provider.UpdateJSONPatch("users", id, []mongoutil.PatchOperation{
+//	    {Op: "test", Path: "/status", Value: "pending"},
+//	    {Op: "replace", Path: "/status", Value: "active"},
+//	    {Op: "add", Path: "/roles/-", Value: "editor"},
+//	})
+func (ma DataProvider) UpdateJSONPatch(collection, id string, operations []PatchOperation) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("updateJSONPatch", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if len(operations) == 0 {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Patch cannot be empty for json patch requests.",
+		}
+		return
+	}
+
+	change := bson.M{}
+	var conditions []bson.M
+	for _, operation := range operations {
+		if conditions, err = ma.patchOperators(collection, change, conditions, operation); err != nil {
+			return
+		}
+	}
+
+	if set, hasSet := change["$set"].(bson.M); hasSet {
+		var coerced map[string]interface{}
+		if coerced, err = ma.coerceElementFields(collection, set); err != nil {
+			return
+		}
+		change["$set"] = bson.M(coerced)
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+
+	if err = ma.validateModified(sessionCopy, collection, id, func(document map[string]interface{}) map[string]interface{} {
+		applyPatchChange(document, change)
+		return document
+	}); err != nil {
+		return
+	}
+
+	after, err := ma.modifyWhere(sessionCopy, collection, id, conditions, change, nil)
+	if err != nil {
+		return
+	}
+
+	op.countWritten(after)
+	ma.runAfterHooks(AfterUpdate, collection, id, after)
+	response = ma.modifyResponse(collection, after)
+	return
+}
+
+// Adds the operators and the conditions of the patch operation.
+func (ma DataProvider) patchOperators(collection string, change bson.M, conditions []bson.M, operation PatchOperation) ([]bson.M, *utils.Error) {
+
+	field, index, err := ma.patchPath(collection, operation.Path)
+	if err != nil {
+		return conditions, err
+	}
+	path := field
+	if index != "" {
+		path = field + "." + index
+	}
+
+	switch operation.Op {
+	case "add":
+		if index == "" {
+			addOperator(change, "$set", path, operation.Value)
+			break
+		}
+		if pushErr := addPush(change, field, index, operation.Value); pushErr != "" {
+			return conditions, patchError(operation, pushErr)
+		}
+	case "remove":
+		if index != "" {
+			return conditions, patchError(operation, "Array elements cannot be removed by index.")
+		}
+		addOperator(change, "$unset", path, "")
+		conditions = append(conditions, bson.M{path: bson.M{"$exists": true}})
+	case "replace":
+		if index == "-" {
+			return conditions, patchError(operation, "Path must point to an existing value.")
+		}
+		addOperator(change, "$set", path, operation.Value)
+		conditions = append(conditions, bson.M{path: bson.M{"$exists": true}})
+	case "move":
+		from, fromIndex, fromErr := ma.patchPath(collection, operation.From)
+		if fromErr != nil {
+			return conditions, fromErr
+		}
+		if index != "" || fromIndex != "" {
+			return conditions, patchError(operation, "Array elements cannot be moved.")
+		}
+		addOperator(change, "$rename", from, path)
+		conditions = append(conditions, bson.M{from: bson.M{"$exists": true}})
+	case "test":
+		if index == "-" {
+			return conditions, patchError(operation, "Path must point to an existing value.")
+		}
+		// the operations are applied together, so the test would see the value before them
+		if changedPath(change, field) {
+			return conditions, patchError(operation, "Path cannot be tested after it is changed.")
+		}
+		// compared as a whole, since a plain filter would match an array containing the value
+		conditions = append(conditions, bson.M{"$expr": bson.M{"$eq": []interface{}{"$" + path, bson.M{"$literal": operation.Value}}}})
+	default:
+		return conditions, patchError(operation, "Operation '"+operation.Op+"' is not supported.")
+	}
+	return conditions, nil
+}
+
+// Returns whether an operator of the update changes the field, one of its
+// parents or one of its children.
+func changedPath(change bson.M, field string) bool {
+
+	var paths []string
+	for operator, fields := range change {
+		for path, value := range fields.(bson.M) {
+			paths = append(paths, path)
+			if operator == "$rename" {
+				paths = append(paths, value.(string))
+			}
+		}
+	}
+	for _, path := range paths {
+		if path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(field, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Applies the update built from the patch to the document, like the server does.
+func applyPatchChange(document map[string]interface{}, change bson.M) {
+
+	for operator, fields := range change {
+		for field, value := range fields.(bson.M) {
+			switch operator {
+			case "$set":
+				setPath(document, field, value)
+			case "$unset":
+				unsetPath(document, field)
+			case "$rename":
+				if moved, found := storedField(document, field); found {
+					unsetPath(document, field)
+					setPath(document, value.(string), moved)
+				}
+			case "$push":
+				push := value.(bson.M)
+				array, _ := fieldValue(document, field).([]interface{})
+				position := len(array)
+				if index, hasPosition := push["$position"].(int); hasPosition && index < position {
+					position = index
+				}
+				pushed := append(append(append([]interface{}{}, array[:position]...), push["$each"].([]interface{})...), array[position:]...)
+				setPath(document, field, pushed)
+			}
+		}
+	}
+}
+
+// Adds the insertion into the array. The values appended to the same array
+// are pushed together, while an insertion at an index must be the only one.
+func addPush(change bson.M, field, index string, value interface{}) (reason string) {
+
+	push := bson.M{"$each": []interface{}{value}}
+	if index != "-" {
+		position, _ := strconv.Atoi(index)
+		push["$position"] = position
+	}
+
+	pushes, _ := change["$push"].(bson.M)
+	existing, hasPush := pushes[field].(bson.M)
+	if !hasPush {
+		addOperator(change, "$push", field, push)
+		return
+	}
+	_, existingPosition := existing["$position"]
+	if existingPosition || index != "-" {
+		return "Only the values appended to the end of an array can be combined with other insertions."
+	}
+	existing["$each"] = append(existing["$each"].([]interface{}), value)
+	return
+}
+
+// Converts the JSON Pointer to a dotted field. A trailing array index, or "-"
+// for the end of the array, is returned separately.
+func (ma DataProvider) patchPath(collection, pointer string) (field, index string, err *utils.Error) {
+
+	if !strings.HasPrefix(pointer, "/") || pointer == "/" {
+		err = newError(ErrBadFilter, "Path '"+pointer+"' must point to a field of the document.")
+		return
+	}
+
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segment = strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+		if segment == "" || strings.Contains(segment, ".") || strings.HasPrefix(segment, "$") {
+			err = newError(ErrBadFilter, "Path '"+pointer+"' is not valid.")
+			return
+		}
+		segments[i] = segment
+	}
+	if err = ma.checkModifiedField(collection, segments[0]); err != nil {
+		return
+	}
+
+	last := segments[len(segments)-1]
+	if number, numberErr := strconv.Atoi(last); len(segments) > 1 && numberErr == nil && (number < 0 || last[0] == '+') {
+		err = newError(ErrBadFilter, "Path '"+pointer+"' must not have a signed array index.")
+		return
+	}
+	if _, numberErr := strconv.Atoi(last); len(segments) > 1 && (last == "-" || numberErr == nil) {
+		index = last
+		segments = segments[:len(segments)-1]
+	}
+	field = strings.Join(segments, ".")
+	return
+}
+
+func patchError(operation PatchOperation, message string) *utils.Error {
+	return newError(ErrBadFilter, "Patch operation '"+operation.Op+"' on '"+operation.Path+"' failed. "+message)
+}
@@ -0,0 +1,177 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"time"
+)
+
+// collection that keeps the values of the key-value API
+var KVCollection = "kv"
+
+// Sets the value of the key. The value is removed after the ttl by a TTL
+// index, which is created on the first use. Values without a ttl never expire.
+// Example Usage:
+// provider.SetValue("flags/new-checkout", true, 0)
+// provider.SetValue("otp/"+phone, code, 5*time.Minute)
+func (ma DataProvider) SetValue(key string, value interface{}, ttl time.Duration) (err *utils.Error) {
+
+	op, err := ma.begin("setValue", KVCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(KVCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(KVCollection)
+
+	// mgo omits a zero ExpireAfter, which would make it a regular index
+	if err = ma.ensureIndexOnce(connection, mgo.Index{Key: []string{"expiresAt"}, ExpireAfter: time.Second}); err != nil {
+		return
+	}
+
+	change := bson.M{"$set": bson.M{"value": value}}
+	if ttl > 0 {
+		change["$set"].(bson.M)["expiresAt"] = time.Now().Add(ttl)
+	} else {
+		change["$unset"] = bson.M{"expiresAt": ""}
+	}
+
+	setErr := ma.retry(sessionCopy, ma.attempts(KVCollection), func() (err error) {
+		_, err = connection.UpsertId(key, change)
+		return
+	})
+	if setErr != nil {
+		err = driverError(setErr, "Setting value of '"+key+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": setErr.Error(),
+			"key":    key,
+		}).Error("Mongo Error: Setting value failed.")
+	}
+	return
+}
+
+// Returns the value of the key. Returns 404 if the key doesn't exist or expired.
+func (ma DataProvider) GetValue(key string) (value interface{}, err *utils.Error) {
+
+	op, err := ma.begin("getValue", KVCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(KVCollection, true, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(KVCollection)
+
+	// the TTL monitor runs once a minute so the expired values may still exist
+	filter := bson.M{
+		ID: key,
+		"$or": []bson.M{
+			{"expiresAt": bson.M{"$exists": false}},
+			{"expiresAt": bson.M{"$gt": time.Now()}},
+		},
+	}
+
+	var item struct {
+		Value interface{} `bson:"value"`
+	}
+	getErr := ma.retry(sessionCopy, ma.attempts(KVCollection), func() (err error) {
+		return connection.Find(filter).One(&item)
+	})
+	if getErr != nil {
+		if getErr == mgo.ErrNotFound {
+			err = newError(ErrNotFound, "Value of '"+key+"' not found.")
+			return
+		}
+		err = driverError(getErr, "Getting value of '"+key+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": getErr.Error(),
+			"key":    key,
+		}).Error("Mongo Error: Getting value failed.")
+		return
+	}
+
+	value = item.Value
+	return
+}
+
+// Removes the key. Removing a key that doesn't exist is not an error.
+func (ma DataProvider) DeleteValue(key string) (err *utils.Error) {
+
+	op, err := ma.begin("deleteValue", KVCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(KVCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(KVCollection)
+
+	deleteErr := ma.retry(sessionCopy, ma.attempts(KVCollection), func() (err error) {
+		return connection.RemoveId(key)
+	})
+	if deleteErr != nil && deleteErr != mgo.ErrNotFound {
+		err = driverError(deleteErr, "Deleting value of '"+key+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": deleteErr.Error(),
+			"key":    key,
+		}).Error("Mongo Error: Deleting value failed.")
+	}
+	return
+}
+
+// Creates the index once per provider. mgo also caches the ensured
+// indexes but only per session.
+func (ma DataProvider) ensureIndexOnce(connection *mgo.Collection, index mgo.Index) (err *utils.Error) {
+
+	name := connection.FullName + "/" + strings.Join(index.Key, ",")
+	if ma.state != nil {
+		ma.state.mutex.RLock()
+		ensured := ma.state.ensuredIndexes[name]
+		ma.state.mutex.RUnlock()
+		if ensured {
+			return
+		}
+	}
+
+	indexErr := connection.EnsureIndex(index)
+	if indexErr != nil {
+		err = driverError(indexErr, "Creating index of '"+connection.Name+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     indexErr.Error(),
+			"collection": connection.Name,
+			"key":        index.Key,
+		}).Error("Mongo Error: Creating index failed.")
+		return
+	}
+
+	if ma.state != nil {
+		ma.state.mutex.Lock()
+		if ma.state.ensuredIndexes == nil {
+			ma.state.ensuredIndexes = make(map[string]bool)
+		}
+		ma.state.ensuredIndexes[name] = true
+		ma.state.mutex.Unlock()
+	}
+	return
+}
@@ -0,0 +1,93 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strconv"
+)
+
+// limits of the input documents if they are not configured. mongo rejects the
+// documents larger than 16MB and nested deeper than 100 levels
+const (
+	DefaultMaxDocumentSize  = 16 * 1024 * 1024
+	DefaultMaxDocumentDepth = 32
+)
+
+// Returns 400 if the document is nested deeper than MaxDocumentDepth and 413 if
+// its BSON encoding is larger than MaxDocumentSize bytes. The depth is checked
+// first, so that the abusive documents are rejected without being encoded.
+func (ma DataProvider) checkDocumentLimits(document map[string]interface{}) (err *utils.Error) {
+	return checkDocumentLimits(document, ma.MaxDocumentSize, ma.MaxDocumentDepth)
+}
+
+func checkDocumentLimits(document map[string]interface{}, maxSize, maxDepth int) (err *utils.Error) {
+
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDocumentSize
+	}
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDocumentDepth
+	}
+
+	if documentDepth(document, maxDepth) > maxDepth {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Input cannot be nested deeper than " + strconv.Itoa(maxDepth) + " levels.",
+		}
+		return
+	}
+
+	// values that cannot be encoded are reported by the operation itself
+	encoded, marshalErr := bson.Marshal(document)
+	if marshalErr == nil && len(encoded) > maxSize {
+		err = &utils.Error{
+			Code:    http.StatusRequestEntityTooLarge,
+			Message: "Input cannot be larger than " + strconv.Itoa(maxSize) + " bytes.",
+		}
+	}
+	return
+}
+
+// Returns the nesting depth of the value, counting the documents and the
+// arrays. Stops descending once the depth exceeds the limit.
+func documentDepth(value interface{}, limit int) int {
+
+	var children []interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	case bson.M:
+		for _, child := range v {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = v
+	default:
+		return 0
+	}
+
+	deepest := 0
+	if limit > 0 {
+		for _, child := range children {
+			if depth := documentDepth(child, limit-1); depth > deepest {
+				deepest = depth
+			}
+			if deepest >= limit {
+				break
+			}
+		}
+	}
+	return deepest + 1
+}
+
+// Returns the limits of the provider handling the request.
+func documentLimits(db dataprovider.Provider) (maxSize, maxDepth int) {
+	if provider, isProvider := db.(*DataProvider); isProvider {
+		return provider.MaxDocumentSize, provider.MaxDocumentDepth
+	}
+	return
+}
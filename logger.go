@@ -0,0 +1,110 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/log"
+	"github.com/sirupsen/logrus"
+)
+
+// fields of a log entry
+type LogFields map[string]interface{}
+
+// Receives the logs of the provider. Set the Logger of the provider to send
+// them to the logging library of the application.
+// Example Usage:
+//
+//	type zapLogger struct{ z *zap.SugaredLogger }
+//
+//	func (l zapLogger) Error(message string, fields mongoutil.LogFields) {
+//	    l.z.Errorw(message, flatten(fields)...)
+//	}
+//	...
+//	provider.Logger = zapLogger{z}
+type Logger interface {
+	Debug(message string, fields LogFields)
+	Info(message string, fields LogFields)
+	Warning(message string, fields LogFields)
+	Error(message string, fields LogFields)
+}
+
+// Logger writing to the logrus logger of rihtim/core, used if no Logger is set.
+type CoreLogger struct{}
+
+func (CoreLogger) Debug(message string, fields LogFields) {
+	log.WithFields(logrus.Fields(fields)).Debug(message)
+}
+
+func (CoreLogger) Info(message string, fields LogFields) {
+	log.WithFields(logrus.Fields(fields)).Info(message)
+}
+
+func (CoreLogger) Warning(message string, fields LogFields) {
+	log.WithFields(logrus.Fields(fields)).Warning(message)
+}
+
+func (CoreLogger) Error(message string, fields LogFields) {
+	log.WithFields(logrus.Fields(fields)).Error(message)
+}
+
+// Returns the logger of the provider, for the packages extending it.
+func (ma DataProvider) Log() Logger {
+	if ma.Logger != nil {
+		return ma.Logger
+	}
+	return CoreLogger{}
+}
+
+// an entry being built, in the style of logrus
+type logEntry struct {
+	logger Logger
+	fields LogFields
+	// level of Log, set by eventLogger
+	level LogLevel
+}
+
+func newLogEntry(logger Logger) logEntry {
+	if logger == nil {
+		logger = CoreLogger{}
+	}
+	return logEntry{logger: logger}
+}
+
+// Example Usage:
+// ma.logger().WithFields(LogFields{"collection": collection}).Error("Mongo Error: ...")
+func (ma DataProvider) logger() logEntry {
+	return newLogEntry(ma.Logger)
+}
+
+func (e logEntry) WithFields(fields LogFields) logEntry {
+	e.fields = fields
+	return e
+}
+
+func (e logEntry) Debug(message string) {
+	e.logger.Debug(message, e.fields)
+}
+
+func (e logEntry) Info(message string) {
+	e.logger.Info(message, e.fields)
+}
+
+func (e logEntry) Warning(message string) {
+	e.logger.Warning(message, e.fields)
+}
+
+func (e logEntry) Error(message string) {
+	e.logger.Error(message, e.fields)
+}
+
+// Logs the message at the level of the entry.
+func (e logEntry) Log(message string) {
+	switch e.level {
+	case LogDebug:
+		e.Debug(message)
+	case LogInfo:
+		e.Info(message)
+	case LogWarning:
+		e.Warning(message)
+	case LogError:
+		e.Error(message)
+	}
+}
@@ -0,0 +1,64 @@
+package mongoutil
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// levels of the log events, LogOff disables the event
+type LogLevel string
+
+const (
+	LogDebug   LogLevel = "debug"
+	LogInfo    LogLevel = "info"
+	LogWarning LogLevel = "warning"
+	LogError   LogLevel = "error"
+	LogOff     LogLevel = "off"
+)
+
+// frequent log events whose level and sampling can be configured with LogPolicies
+const (
+	// an attempt failed and will be retried
+	LogEventRetry = "retry"
+	// the last attempt failed
+	LogEventRetryExhausted = "retryExhausted"
+	// the session is refreshed after a connection error
+	LogEventSessionRefresh = "sessionRefresh"
+)
+
+// Level and sampling of a log event. Zero values keep the defaults of the event.
+// Example Usage:
+//
+//	provider.LogPolicies = map[string]mongoutil.LogPolicy{
+//	    mongoutil.LogEventRetry: {Level: mongoutil.LogWarning, SampleEvery: 100},
+//	}
+type LogPolicy struct {
+	Level LogLevel
+	// only 1 in SampleEvery occurrences of the event are logged
+	SampleEvery int64
+}
+
+// occurrences of the sampled log events, kept in providerState
+type logSamples struct {
+	counts sync.Map
+}
+
+// Returns the entry of the event, which is logged at the configured level.
+// The entry is disabled if the event is off or this occurrence is not sampled.
+func (ma DataProvider) eventLogger(event string, defaultLevel LogLevel) (entry logEntry) {
+
+	entry = ma.logger()
+	entry.level = defaultLevel
+
+	policy := ma.LogPolicies[event]
+	if policy.Level != "" {
+		entry.level = policy.Level
+	}
+	if policy.SampleEvery > 1 && ma.state != nil {
+		count, _ := ma.state.logSamples.counts.LoadOrStore(event, new(int64))
+		if (atomic.AddInt64(count.(*int64), 1)-1)%policy.SampleEvery != 0 {
+			entry.level = LogOff
+		}
+	}
+	return
+}
@@ -0,0 +1,159 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"time"
+)
+
+// Updates the document with a JSON Merge Patch (RFC 7386). Null values remove
+// the fields and nested objects are merged recursively, while the other values,
+// including arrays, replace the stored ones. The patch is applied atomically
+// with dotted $set and $unset paths, so a nested object can only be merged
+// into a stored object. Encrypted fields are replaced as a whole.
+// Example Usage:
+//
+//	provider.UpdateMergePatch("users", id, map[string]interface{}{
+//	    "address":  map[string]interface{}{"city": "Izmir", "zip": nil},
+//	    "nickname": nil,
+//	})
+func (ma DataProvider) UpdateMergePatch(collection, id string, patch map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("updateMergePatch", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if len(patch) == 0 {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Patch cannot be empty for merge patch requests.",
+		}
+		return
+	}
+	for field := range patch {
+		if ma.Encryption != nil && containsString(ma.Encryption.Fields[collection], field) {
+			continue
+		}
+		if err = ma.checkModifiedField(collection, field); err != nil {
+			return
+		}
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+
+	if err = ma.runHooks(BeforeUpdate, collection, id, patch); err != nil {
+		return
+	}
+
+	// the patched document is only built if there is a schema to validate it against
+	if ma.hasSchema(collection) {
+		var filter bson.M
+		if filter, _, err = ma.scopedIdFilter(collection, id); err != nil {
+			return
+		}
+		var stored map[string]interface{}
+		findErr := ma.retry(sessionCopy, ma.attempts(collection), func() error {
+			return sessionCopy.DB(ma.Database).C(collection).Find(filter).One(&stored)
+		})
+		if findErr != nil {
+			err = driverError(findErr, "Getting '"+collection+"' with id '"+id+"' failed.")
+			return
+		}
+		if err = ma.Encryption.decrypt(collection, stored); err != nil {
+			return
+		}
+		if err = ma.validateSchema(collection, applyMergePatch(stored, patch).(map[string]interface{})); err != nil {
+			return
+		}
+	}
+
+	change := bson.M{}
+	encrypted := make(map[string]interface{})
+	for field, value := range patch {
+		if ma.Encryption != nil && containsString(ma.Encryption.Fields[collection], field) {
+			if value == nil {
+				addOperator(change, "$unset", field, "")
+			} else {
+				encrypted[field] = value
+			}
+			continue
+		}
+		mergePatchOperators(change, field, value)
+	}
+	if err = ma.Encryption.encrypt(collection, encrypted); err != nil {
+		return
+	}
+	for field, value := range encrypted {
+		addOperator(change, "$set", field, value)
+	}
+
+	after, err := ma.modify(sessionCopy, collection, id, change, nil)
+	if err != nil {
+		return
+	}
+
+	op.countWritten(after)
+	ma.runAfterHooks(AfterUpdate, collection, id, after)
+	response = ma.modifyResponse(collection, after)
+	return
+}
+
+// Adds the operators applying the patch value to the field.
+func mergePatchOperators(change bson.M, field string, value interface{}) {
+
+	if value == nil {
+		addOperator(change, "$unset", field, "")
+		return
+	}
+	if object, isObject := asObject(value); isObject {
+		for k, nested := range object {
+			mergePatchOperators(change, field+"."+k, nested)
+		}
+		return
+	}
+	addOperator(change, "$set", field, value)
+}
+
+// Returns the document that the patch produces from the target, as defined by RFC 7386.
+func applyMergePatch(target interface{}, patch interface{}) interface{} {
+
+	object, isObject := asObject(patch)
+	if !isObject {
+		return patch
+	}
+
+	result := make(map[string]interface{})
+	if targetObject, isObject := asObject(target); isObject {
+		for k, v := range targetObject {
+			result[k] = v
+		}
+	}
+	for k, v := range object {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		result[k] = applyMergePatch(result[k], v)
+	}
+	return result
+}
+
+// Returns the value as a document. The stored nested documents are read as bson.M.
+func asObject(value interface{}) (object map[string]interface{}, isObject bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case bson.M:
+		return v, true
+	}
+	return
+}
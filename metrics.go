@@ -0,0 +1,124 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"time"
+)
+
+// names of the operations used in metrics and logs
+const (
+	OpCreate     = "create"
+	OpGet        = "get"
+	OpQuery      = "query"
+	OpUpdate     = "update"
+	OpDelete     = "delete"
+	OpCreateFile = "createFile"
+	OpGetFile    = "getFile"
+)
+
+// an operation in progress, created by begin and finished by track
+type operation struct {
+	name       string
+	collection string
+	start      time.Time
+
+	// fingerprint of the filter or pipeline, only set by queries
+	shape string
+	span  trace.Span
+
+	accounting bool
+	usage      Usage
+
+	// whether the operation is counted by the in-flight operations Close waits for
+	inFlight bool
+}
+
+// Marks the beginning of an operation. Returns 503 once the provider is
+// closing, or if the provider is a copy made before the session was closed.
+// Example Usage:
+//
+//	op, err := ma.begin(OpGet, collection)
+//	defer ma.track(op, &err)
+//	defer ma.recoverPanic(op, &err)
+//	if err != nil {
+//	    return
+//	}
+func (ma DataProvider) begin(name, collection string) (op *operation, err *utils.Error) {
+	op = &operation{
+		name:       name,
+		collection: collection,
+		start:      time.Now(),
+		accounting: ma.CostAccounting,
+	}
+	if ma.state != nil {
+		// the counter must not be increased while Close waits for it to reach zero
+		ma.state.lifecycle.RLock()
+		if ma.state.closing || ma.session == nil || ma.session != ma.state.session {
+			err = &utils.Error{
+				Code:    http.StatusServiceUnavailable,
+				Message: "Database connection is closed.",
+			}
+		} else {
+			ma.state.inFlight.Add(1)
+			op.inFlight = true
+		}
+		ma.state.lifecycle.RUnlock()
+	}
+	ma.countStarted()
+	ma.startSpan(op)
+	ma.monitorStarted(op)
+	return
+}
+
+// Returns the shape label of the operation in the metrics. The shapes come from
+// the filters of the clients, so only the hashes declared in the ShapeHints of
+// the collection are reported to keep the number of series bounded. The others
+// are reported as "other", and their hashes are logged with the slow operations.
+func (ma DataProvider) metricShape(op *operation) string {
+
+	if op.shape == "" {
+		return ""
+	}
+	hash := fingerprintHash(op.shape)
+	if _, isDeclared := ma.CollectionPolicies[op.collection].ShapeHints[hash]; isDeclared {
+		return hash
+	}
+	return "other"
+}
+
+// Reports the finished operation to the configured metric emitters.
+func (ma DataProvider) track(op *operation, err **utils.Error) {
+
+	if op.inFlight {
+		defer ma.state.inFlight.Done()
+	}
+
+	duration := time.Since(op.start)
+	failed := *err != nil
+	shape := ma.metricShape(op)
+	ma.countFinished(failed)
+
+	if ma.StatsD != nil {
+		ma.StatsD.ObserveOperation(op.name, op.collection, shape, duration, failed)
+	}
+	if ma.Prometheus != nil {
+		ma.Prometheus.ObserveOperation(op.name, op.collection, shape, duration, failed)
+	}
+	ma.endSpan(op, *err)
+	ma.monitorFinished(op, duration, *err)
+	ma.recordUsage(op)
+
+	// only the shape of the filter is logged since the values may be sensitive
+	if ma.SlowOperationThreshold > 0 && duration > ma.SlowOperationThreshold {
+		ma.logger().WithFields(LogFields{
+			"operation":  op.name,
+			"collection": op.collection,
+			"shape":      op.shape,
+			"shapeHash":  fingerprintHash(op.shape),
+			"duration":   duration.String(),
+			"failed":     failed,
+		}).Warning("Mongo Warning: Slow operation.")
+	}
+}
@@ -0,0 +1,201 @@
+package migrations
+
+import (
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"github.com/rihtim/mongoutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// collection that the applied migrations are recorded in
+const Collection = "migrations"
+
+// id of the document in Collection that is held while migrating
+const lockId = "lock"
+
+// locks older than this are considered abandoned by a crashed instance
+var LockTimeout = 10 * time.Minute
+
+// A versioned migration step. Versions must be unique and are applied in
+// increasing order, each one only once.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(provider *mongoutil.DataProvider) *utils.Error
+}
+
+// Applies the migrations that are not applied yet. Only one instance can
+// migrate at a time, the others fail with 409 while the lock is held.
+// Returns the versions that are applied by this call.
+// Example Usage:
+//
+//	applied, err := migrations.Run(provider, []migrations.Migration{
+//	    {Version: 1, Name: "add-user-roles", Up: addUserRoles},
+//	})
+func Run(provider *mongoutil.DataProvider, steps []Migration) (applied []int, err *utils.Error) {
+
+	pending, err := Pending(provider, steps)
+	if err != nil || len(pending) == 0 {
+		return
+	}
+
+	if err = lock(provider); err != nil {
+		return
+	}
+	defer unlock(provider)
+
+	// another instance may have migrated before the lock was taken
+	if pending, err = Pending(provider, steps); err != nil {
+		return
+	}
+
+	for _, step := range pending {
+		provider.Log().Info("Applying migration.", mongoutil.LogFields{
+			"version": step.Version,
+			"name":    step.Name,
+		})
+
+		if err = step.Up(provider); err != nil {
+			provider.Log().Error("Mongo Error: Migration failed.", mongoutil.LogFields{
+				"reason":  err.Error(),
+				"version": step.Version,
+				"name":    step.Name,
+			})
+			return
+		}
+
+		_, err = provider.Create(Collection, map[string]interface{}{
+			mongoutil.ID: strconv.Itoa(step.Version),
+			"version":    step.Version,
+			"name":       step.Name,
+		})
+		if err != nil {
+			return
+		}
+		applied = append(applied, step.Version)
+	}
+	return
+}
+
+// Returns the migrations that are not applied yet, ordered by version.
+func Pending(provider *mongoutil.DataProvider, steps []Migration) (pending []Migration, err *utils.Error) {
+
+	applied, err := appliedVersions(provider)
+	if err != nil {
+		return
+	}
+
+	for _, step := range steps {
+		if !applied[step.Version] {
+			pending = append(pending, step)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+	return
+}
+
+func appliedVersions(provider *mongoutil.DataProvider) (applied map[int]bool, err *utils.Error) {
+
+	response, err := provider.Query(Collection, map[string][]string{
+		"where": {`{"version": {"$exists": true}}`},
+	})
+	if err != nil {
+		return
+	}
+
+	applied = make(map[int]bool)
+	results, _ := response[mongoutil.List].([]map[string]interface{})
+	for _, result := range results {
+		switch version := result["version"].(type) {
+		case int:
+			applied[version] = true
+		case int64:
+			applied[int(version)] = true
+		case float64:
+			applied[int(version)] = true
+		}
+	}
+	return
+}
+
+func lock(provider *mongoutil.DataProvider) (err *utils.Error) {
+
+	_, err = provider.Create(Collection, map[string]interface{}{mongoutil.ID: lockId})
+	if mongoutil.KindOf(err) != mongoutil.ErrDuplicate {
+		return
+	}
+
+	// the lock is taken over if its holder didn't release it in time
+	existing, getErr := provider.Get(Collection, lockId)
+	if getErr == nil {
+		createdAtField, _ := provider.TimestampFieldNames(Collection)
+		createdAt := mongoutil.TimestampTime(existing[createdAtField])
+		if time.Since(createdAt) > LockTimeout {
+			provider.Log().Warning("Mongo Warning: Taking over abandoned migration lock.", nil)
+			unlock(provider)
+			_, err = provider.Create(Collection, map[string]interface{}{mongoutil.ID: lockId})
+			if mongoutil.KindOf(err) != mongoutil.ErrDuplicate {
+				return
+			}
+		}
+	}
+
+	err = &utils.Error{
+		Code:    http.StatusConflict,
+		Message: "Migrations are being run by another instance.",
+	}
+	return
+}
+
+func unlock(provider *mongoutil.DataProvider) {
+	if _, err := provider.Delete(Collection, lockId); err != nil {
+		provider.Log().Error("Mongo Error: Releasing migration lock failed.", mongoutil.LogFields{
+			"reason": err.Error(),
+		})
+	}
+}
+
+// Runs the command given in the arguments, meant to be called from main
+// at startup. Returns false if the arguments are not a migration command
+// so the service can continue starting.
+//
+//	migrate        applies the pending migrations and exits
+//	migrate status lists the pending migrations and exits
+//
+// Example Usage:
+// if migrations.Command(provider, steps, os.Args[1:]) { return }
+func Command(provider *mongoutil.DataProvider, steps []Migration, args []string) (handled bool) {
+
+	if len(args) == 0 || args[0] != "migrate" {
+		return
+	}
+	handled = true
+
+	if len(args) > 1 && args[1] == "status" {
+		pending, err := Pending(provider, steps)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		for _, step := range pending {
+			fmt.Printf("pending %d %s\n", step.Version, step.Name)
+		}
+		return
+	}
+
+	applied, err := Run(provider, steps)
+	for _, version := range applied {
+		fmt.Printf("applied %d\n", version)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	return
+}
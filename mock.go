@@ -0,0 +1,435 @@
+package mongoutil
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ dataprovider.Provider = &MockProvider{}
+
+// In-memory implementation of the provider for unit tests of interceptors.
+// Query supports the where, sort, limit, skip and withCount parameters with equality and
+// the $eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $exists, $type (null only), $regex,
+// $and, $or and $nor operators. Aggregations are not supported.
+// Example Usage:
+// db := mongoutil.NewMockProvider()
+// _, err := ValidateInput(rs, nil, req, res, db)
+type MockProvider struct {
+	TimestampMode TimestampMode
+
+	mutex       sync.RWMutex
+	collections map[string]map[string]map[string]interface{}
+	files       map[string][]byte
+}
+
+func NewMockProvider() *MockProvider {
+	return &MockProvider{
+		collections: make(map[string]map[string]map[string]interface{}),
+		files:       make(map[string][]byte),
+	}
+}
+
+func (m *MockProvider) Connect() (err *utils.Error) {
+	return
+}
+
+func (m *MockProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.collections[collection] == nil {
+		m.collections[collection] = make(map[string]map[string]interface{})
+	}
+
+	if id, hasId := data[ID]; !hasId || id == "" {
+		data[ID] = bson.NewObjectId().Hex()
+	}
+	id := fmt.Sprint(data[ID])
+	if _, exists := m.collections[collection][id]; exists {
+		err = newError(ErrDuplicate, "'"+collection+"' with id '"+id+"' already exists.")
+		return
+	}
+
+	createdAt := m.TimestampMode.value(time.Now())
+	data[CreatedAt] = createdAt
+	data[UpdatedAt] = createdAt
+	m.collections[collection][id] = copyDocument(data)
+
+	response = map[string]interface{}{
+		ID:        data[ID],
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+	return
+}
+
+func (m *MockProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	document, exists := m.collections[collection][id]
+	if !exists {
+		err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		return
+	}
+	response = copyDocument(document)
+	return
+}
+
+func (m *MockProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+
+	if _, hasAggregate := parameters["aggregate"]; hasAggregate {
+		err = &utils.Error{
+			Code:    http.StatusNotImplemented,
+			Message: "Aggregation is not supported by the mock provider.",
+		}
+		return
+	}
+
+	where, _, err := extractJsonParameter(parameters, "where")
+	if err != nil {
+		return
+	}
+	sortParam, hasSort, err := extractStringParameter(parameters, "sort")
+	if err != nil {
+		return
+	}
+	limit, _, err := extractIntParameter(parameters, "limit")
+	if err != nil {
+		return
+	}
+	skip, _, err := extractIntParameter(parameters, "skip")
+	if err != nil {
+		return
+	}
+	withCount, _, err := extractBoolParameter(parameters, "withCount")
+	if err != nil {
+		return
+	}
+
+	m.mutex.RLock()
+	results := make([]map[string]interface{}, 0)
+	for _, document := range m.collections[collection] {
+		if matchesFilter(document, where) {
+			results = append(results, copyDocument(document))
+		}
+	}
+	m.mutex.RUnlock()
+
+	// documents are returned in insertion order unless sorted, like the natural order of mongo
+	sortFields := []string{"+" + CreatedAt, "+" + ID}
+	if hasSort {
+		sortFields = strings.Split(sortParam, ",")
+	}
+	sortDocuments(results, sortFields)
+
+	total := len(results)
+	if skip > len(results) {
+		results = results[len(results):]
+	} else {
+		results = results[skip:]
+	}
+	hasMore := limit > 0 && limit < len(results)
+	if hasMore {
+		results = results[:limit]
+	}
+
+	response = map[string]interface{}{
+		List:    results,
+		Skip:    skip,
+		Limit:   limit,
+		HasMore: hasMore,
+	}
+	if withCount {
+		response[Total] = total
+	}
+	return
+}
+
+func (m *MockProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	if data == nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Request body cannot be empty for update requests.",
+		}
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	document, exists := m.collections[collection][id]
+	if !exists {
+		err = newError(ErrNotFound, "Item not found.")
+		return
+	}
+
+	if err = checkUpdatePaths(data); err != nil {
+		return
+	}
+
+	updatedAt := m.TimestampMode.value(time.Now())
+	for k, v := range data {
+		setPath(document, k, v)
+	}
+	document[UpdatedAt] = updatedAt
+
+	response = map[string]interface{}{
+		UpdatedAt: updatedAt,
+	}
+	return
+}
+
+func (m *MockProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, exists := m.collections[collection][id]; !exists {
+		err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		return
+	}
+	delete(m.collections[collection], id)
+	return
+}
+
+func (m *MockProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
+
+	if data == nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Request body cannot be empty for create file requests.",
+		}
+		return
+	}
+
+	content, readErr := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, data))
+	if readErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Writing file failed.",
+		}
+		return
+	}
+
+	id := bson.NewObjectId().Hex()
+	m.mutex.Lock()
+	m.files[id] = content
+	m.mutex.Unlock()
+
+	response = map[string]interface{}{
+		ID:        id,
+		CreatedAt: m.TimestampMode.value(time.Now()),
+	}
+	return
+}
+
+func (m *MockProvider) GetFile(id string) (response []byte, err *utils.Error) {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	content, exists := m.files[id]
+	if !exists {
+		err = newError(ErrNotFound, "File not found.")
+		return
+	}
+	response = append([]byte{}, content...)
+	return
+}
+
+func copyDocument(document map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(document))
+	for k, v := range document {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Returns whether the document matches the where filter.
+func matchesFilter(document map[string]interface{}, filter interface{}) bool {
+
+	conditions, _ := filter.(map[string]interface{})
+	for key, condition := range conditions {
+		switch key {
+		case "$and", "$or", "$nor":
+			clauses, _ := condition.([]interface{})
+			matched := 0
+			for _, clause := range clauses {
+				if matchesFilter(document, clause) {
+					matched++
+				}
+			}
+			if (key == "$and" && matched != len(clauses)) || (key == "$or" && matched == 0) || (key == "$nor" && matched > 0) {
+				return false
+			}
+		default:
+			value, exists := lookupField(document, key)
+			if !matchesCondition(value, exists, condition) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesCondition(value interface{}, exists bool, condition interface{}) bool {
+
+	operators, isMap := condition.(map[string]interface{})
+	if !isMap || !hasOperatorKeys(operators) {
+		return exists && valuesEqual(value, condition)
+	}
+
+	for operator, operand := range operators {
+		var matched bool
+		switch operator {
+		case "$eq":
+			matched = exists && valuesEqual(value, operand)
+		case "$ne":
+			matched = !exists || !valuesEqual(value, operand)
+		case "$gt":
+			matched = exists && compareValues(value, operand) > 0
+		case "$gte":
+			matched = exists && compareValues(value, operand) >= 0
+		case "$lt":
+			matched = exists && compareValues(value, operand) < 0
+		case "$lte":
+			matched = exists && compareValues(value, operand) <= 0
+		case "$in", "$nin":
+			options, _ := operand.([]interface{})
+			for _, option := range options {
+				if exists && valuesEqual(value, option) {
+					matched = true
+					break
+				}
+			}
+			if operator == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			matched = exists == (operand == true)
+		case "$type":
+			// only the null type of NullFilter is supported
+			matched = exists && value == nil && operand == "null"
+		case "$regex":
+			pattern, _ := operand.(string)
+			text, isString := value.(string)
+			expression, compileErr := regexp.Compile(pattern)
+			matched = isString && compileErr == nil && expression.MatchString(text)
+		default:
+			matched = false
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func hasOperatorKeys(condition map[string]interface{}) bool {
+	for k := range condition {
+		if strings.HasPrefix(k, "$") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value of the field, which can be in dot notation for embedded documents.
+func lookupField(document map[string]interface{}, field string) (value interface{}, exists bool) {
+
+	var current interface{} = document
+	for _, part := range strings.Split(field, ".") {
+		embedded, isMap := current.(map[string]interface{})
+		if !isMap {
+			return nil, false
+		}
+		if current, exists = embedded[part]; !exists {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// Numbers are compared by value regardless of their types, like mongo does.
+func valuesEqual(a, b interface{}) bool {
+	if isNumber(a) && isNumber(b) {
+		return toFloat(a) == toFloat(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int32, int64, float32, float64:
+		return true
+	}
+	return false
+}
+
+// Compares numbers, strings and times, values of different types are considered equal.
+func compareValues(a, b interface{}) int {
+
+	if isNumber(a) && isNumber(b) {
+		x, y := toFloat(a), toFloat(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	if x, isTime := a.(time.Time); isTime {
+		if y, isOtherTime := b.(time.Time); isOtherTime {
+			switch {
+			case x.Before(y):
+				return -1
+			case x.After(y):
+				return 1
+			}
+			return 0
+		}
+	}
+
+	x, isString := a.(string)
+	y, isOtherString := b.(string)
+	if isString && isOtherString {
+		return strings.Compare(x, y)
+	}
+	return 0
+}
+
+// Sorts by the fields, prefixed with '-' for descending order.
+func sortDocuments(documents []map[string]interface{}, fields []string) {
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, field := range fields {
+			field = strings.TrimSpace(field)
+			descending := strings.HasPrefix(field, "-")
+			field = strings.TrimLeft(field, "+-")
+
+			a, _ := lookupField(documents[i], field)
+			b, _ := lookupField(documents[j], field)
+			if c := compareValues(a, b); c != 0 {
+				return (c < 0) != descending
+			}
+		}
+		return false
+	})
+}
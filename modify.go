@@ -0,0 +1,260 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Applies the update operators to the document atomically with findAndModify
+// and returns the updated document. updatedAt is set and the version of the
+// versioned collections is increased along with the change. The command is run
+// directly since mgo's Apply doesn't support arrayFilters.
+func (ma DataProvider) modify(session *mgo.Session, collection, id string, change bson.M, arrayFilters []interface{}) (after map[string]interface{}, err *utils.Error) {
+	return ma.modifyWhere(session, collection, id, nil, change, arrayFilters)
+}
+
+// Modifies the document only if it also matches all the conditions. Returns
+// 409 if the document exists but doesn't match them.
+func (ma DataProvider) modifyWhere(session *mgo.Session, collection, id string, conditions []bson.M, change bson.M, arrayFilters []interface{}) (after map[string]interface{}, err *utils.Error) {
+
+	_, updatedAtField := ma.TimestampFieldNames(collection)
+	if updatedAtField != "" {
+		if ma.TimestampMode == TimestampDate {
+			addOperator(change, "$currentDate", updatedAtField, true)
+		} else {
+			addOperator(change, "$set", updatedAtField, ma.now())
+		}
+	}
+	if ma.isVersioned(collection) {
+		addOperator(change, "$inc", Version, 1)
+	}
+
+	// the documents out of the scope of the requester are not found
+	filter, _, err := ma.scopedIdFilter(collection, id)
+	if err != nil {
+		return
+	}
+	query := filter
+	if len(conditions) > 0 {
+		clauses := []interface{}{filter}
+		for _, condition := range conditions {
+			clauses = append(clauses, condition)
+		}
+		query = bson.M{"$and": clauses}
+	}
+
+	command := bson.D{
+		{Name: "findAndModify", Value: collection},
+		{Name: "query", Value: query},
+		{Name: "update", Value: change},
+		{Name: "new", Value: true},
+	}
+	if len(arrayFilters) > 0 {
+		command = append(command, bson.DocElem{Name: "arrayFilters", Value: arrayFilters})
+	}
+
+	var result struct {
+		Value map[string]interface{} `bson:"value"`
+	}
+	// the operators like $inc and $push would be applied twice by a retried attempt
+	modifyErr := ma.retryWrite(session, ma.attempts(collection), func() error {
+		return session.DB(ma.Database).Run(command, &result)
+	})
+	if modifyErr == nil && result.Value == nil {
+		modifyErr = mgo.ErrNotFound
+	}
+	if modifyErr == mgo.ErrNotFound && len(conditions) > 0 {
+		if count, countErr := session.DB(ma.Database).C(collection).Find(filter).Count(); countErr == nil && count > 0 {
+			err = newError(ErrConflict, "'"+collection+"' with id '"+id+"' doesn't match the conditions of the change.")
+			return
+		}
+	}
+	if modifyErr != nil {
+		if modifyErr == mgo.ErrNotFound {
+			err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		} else {
+			err = driverError(modifyErr, "Updating '"+collection+"' with id '"+id+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     modifyErr.Error(),
+			"collection": collection,
+			"id":         id,
+		}).Error("Mongo Error: Updating item failed.")
+		return
+	}
+
+	after = result.Value
+	ma.uncache(collection, id)
+	ma.InvalidateQueries(collection)
+	ma.publishChange(ChangeEvent{Type: ChangeUpdate, Collection: collection, ID: id, After: after})
+	return
+}
+
+func addOperator(change bson.M, operator, field string, value interface{}) {
+	fields, _ := change[operator].(bson.M)
+	if fields == nil {
+		fields = bson.M{}
+		change[operator] = fields
+	}
+	fields[field] = value
+}
+
+// Returns the response of the operations modifying the document.
+func (ma DataProvider) modifyResponse(collection string, after map[string]interface{}) (response map[string]interface{}) {
+
+	_, updatedAtField := ma.TimestampFieldNames(collection)
+	response = make(map[string]interface{})
+	setField(response, updatedAtField, after[updatedAtField])
+	if ma.isVersioned(collection) {
+		response[Version] = after[Version]
+	}
+	return
+}
+
+// Sets the fields of the array elements matched by the array filters, using the
+// filtered positional operator. The identifiers in the fields are defined by
+// the filters, which match the elements rather than the documents.
+// The fields maintained by the provider and the encrypted fields cannot be
+// set, and the values of the declared FieldTypes are converted. If the
+// collection has a schema, the document is validated as if the filters matched
+// all the elements, since the server decides which elements they match.
+// Example Usage:
+//
+//	provider.UpdateElements("orders", id,
+//	    map[string]interface{}{"items.$[item].qty": 2},
+//	    []interface{}{map[string]interface{}{"item.sku": "abc"}})
+func (ma DataProvider) UpdateElements(collection, id string, set map[string]interface{}, arrayFilters []interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("updateElements", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if len(set) == 0 {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Fields to set cannot be empty for update elements requests.",
+		}
+		return
+	}
+	if err = ma.Operators.checkFilter(arrayFilters); err != nil {
+		return
+	}
+	for field := range set {
+		if err = ma.checkModifiedField(collection, strings.Split(field, ".")[0]); err != nil {
+			return
+		}
+	}
+	if set, err = ma.coerceElementFields(collection, set); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+
+	if err = ma.validateModified(sessionCopy, collection, id, func(document map[string]interface{}) map[string]interface{} {
+		for field, value := range set {
+			document = setElements(document, strings.Split(field, "."), value).(map[string]interface{})
+		}
+		return document
+	}); err != nil {
+		return
+	}
+
+	after, err := ma.modify(sessionCopy, collection, id, bson.M{"$set": set}, arrayFilters)
+	if err != nil {
+		return
+	}
+
+	op.countWritten(after)
+	response = ma.modifyResponse(collection, after)
+	return
+}
+
+// Reads the stored document, changes it with apply and validates the result
+// against the schema of the collection. Does nothing if there is no schema.
+func (ma DataProvider) validateModified(session *mgo.Session, collection, id string, apply func(document map[string]interface{}) map[string]interface{}) (err *utils.Error) {
+
+	if !ma.hasSchema(collection) {
+		return
+	}
+
+	filter, _, err := ma.scopedIdFilter(collection, id)
+	if err != nil {
+		return
+	}
+	var stored map[string]interface{}
+	findErr := ma.retry(session, ma.attempts(collection), func() error {
+		return session.DB(ma.Database).C(collection).Find(filter).One(&stored)
+	})
+	if findErr != nil {
+		if findErr == mgo.ErrNotFound {
+			err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		} else {
+			err = driverError(findErr, "Getting '"+collection+"' with id '"+id+"' failed.")
+		}
+		return
+	}
+	if err = ma.Encryption.decrypt(collection, stored); err != nil {
+		return
+	}
+	return ma.validateSchema(collection, apply(stored))
+}
+
+// Sets the value of the path, whose positional segments, e.g. "$[item]", match
+// all the elements of the arrays. Returns a copy of the changed value.
+func setElements(value interface{}, segments []string, newValue interface{}) interface{} {
+
+	if len(segments) == 0 {
+		return newValue
+	}
+	if strings.HasPrefix(segments[0], "$") {
+		array, isArray := value.([]interface{})
+		if !isArray {
+			return value
+		}
+		copied := make([]interface{}, len(array))
+		for i, element := range array {
+			copied[i] = setElements(element, segments[1:], newValue)
+		}
+		return copied
+	}
+	document, _ := asObject(value)
+	document = copyDocument(document)
+	document[segments[0]] = setElements(document[segments[0]], segments[1:], newValue)
+	return document
+}
+
+// Converts the values of the declared fields like coerceFields, matching the
+// paths with their positional segments removed, e.g. "items.$[item].qty" with
+// the declared "items.qty". Returns a copy of the fields.
+func (ma DataProvider) coerceElementFields(collection string, set map[string]interface{}) (coerced map[string]interface{}, err *utils.Error) {
+
+	coerced = make(map[string]interface{}, len(set))
+	for field, value := range set {
+		segments := make([]string, 0)
+		for _, segment := range strings.Split(field, ".") {
+			if !strings.HasPrefix(segment, "$") {
+				segments = append(segments, segment)
+			}
+		}
+
+		declared := map[string]interface{}{strings.Join(segments, "."): value}
+		if err = ma.coerceFields(collection, declared); err != nil {
+			return
+		}
+		coerced[field] = declared[strings.Join(segments, ".")]
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"time"
+)
+
+// An operation reported to the monitors. Duration and Error are only set
+// when the operation finishes.
+type OperationEvent struct {
+	Operation  string
+	Collection string
+	Duration   time.Duration
+	Error      *utils.Error
+}
+
+// Receives the operations of the provider, for APM and logging integrations.
+// The callbacks are called on the goroutine of the operation, so they must
+// return quickly.
+// Example Usage:
+// provider.Monitors = append(provider.Monitors, apmMonitor{})
+type OperationMonitor interface {
+	Started(event OperationEvent)
+	Succeeded(event OperationEvent)
+	Failed(event OperationEvent)
+}
+
+func (ma DataProvider) monitorStarted(op *operation) {
+	for _, monitor := range ma.Monitors {
+		monitor.Started(OperationEvent{Operation: op.name, Collection: op.collection})
+	}
+}
+
+func (ma DataProvider) monitorFinished(op *operation, duration time.Duration, err *utils.Error) {
+
+	event := OperationEvent{
+		Operation:  op.name,
+		Collection: op.collection,
+		Duration:   duration,
+		Error:      err,
+	}
+	for _, monitor := range ma.Monitors {
+		if err != nil {
+			monitor.Failed(event)
+		} else {
+			monitor.Succeeded(event)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// Returns the filter matching the documents whose field is null. Unlike
+// {field: null}, it doesn't match the documents that don't have the field.
+// The same filter is given to Query with the field[null]=true parameter.
+// Example Usage:
+// where := mongoutil.NullFilter("deletedBy")
+func NullFilter(field string) map[string]interface{} {
+	return map[string]interface{}{field: map[string]interface{}{"$type": "null"}}
+}
+
+// Returns the filter matching the documents that don't have the field. Unlike
+// {field: null}, it doesn't match the documents whose field is null.
+// The same filter is given to Query with the field[exists]=false parameter.
+// Example Usage:
+// where := mongoutil.MissingFilter("deletedBy")
+func MissingFilter(field string) map[string]interface{} {
+	return map[string]interface{}{field: map[string]interface{}{"$exists": false}}
+}
+
+// Splits the update into the fields to be set and the fields to be removed,
+// which are the fields given as null if UnsetNulls is set.
+func (ma DataProvider) splitNulls(data map[string]interface{}) (set, unset bson.M) {
+
+	set = make(bson.M, len(data))
+	unset = make(bson.M)
+	for field, value := range data {
+		if value == nil && ma.UnsetNulls {
+			unset[field] = ""
+			continue
+		}
+		set[field] = value
+	}
+	return
+}
+
+// Removes the fields given as null from the document to be created if UnsetNulls is set.
+func (ma DataProvider) dropNulls(document map[string]interface{}) {
+
+	if !ma.UnsetNulls {
+		return
+	}
+	for field, value := range document {
+		if value == nil {
+			delete(document, field)
+		}
+	}
+}
+
+// Applies the field of the update to the document like the update does.
+func (ma DataProvider) applyField(document map[string]interface{}, field string, value interface{}) {
+	if value == nil && ma.UnsetNulls {
+		unsetPath(document, field)
+		return
+	}
+	setPath(document, field, value)
+}
+
+// Removes the dot-notation path like $unset does. The embedded documents on the
+// path are copied, so the documents sharing them with the document are not changed.
+func unsetPath(document map[string]interface{}, path string) {
+
+	segments := strings.SplitN(path, ".", 2)
+	if len(segments) == 1 {
+		delete(document, path)
+		return
+	}
+
+	embedded, isObject := asObject(document[segments[0]])
+	if !isObject {
+		return
+	}
+	embedded = copyDocument(embedded)
+	unsetPath(embedded, segments[1])
+	document[segments[0]] = embedded
+}
@@ -0,0 +1,52 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (ma DataProvider) isObjectIdCollection(collection string) bool {
+	return ma.ObjectIds[collection]
+}
+
+// Returns the id as it is stored in the collection. Hex ids are converted to
+// ObjectIds for the collections in ObjectIds, other ids are returned as they are.
+func (ma DataProvider) storedId(collection string, id interface{}) interface{} {
+
+	if !ma.isObjectIdCollection(collection) {
+		return id
+	}
+	if hex, isString := id.(string); isString && bson.IsObjectIdHex(hex) {
+		return bson.ObjectIdHex(hex)
+	}
+	return id
+}
+
+// Same as storedId for a list of ids.
+func (ma DataProvider) storedIds(collection string, ids []string) []interface{} {
+	stored := make([]interface{}, len(ids))
+	for i, id := range ids {
+		stored[i] = ma.storedId(collection, id)
+	}
+	return stored
+}
+
+// Converts the ObjectId ids of the documents read from the collection to hex
+// strings, so the responses are the same as the collections storing strings.
+func (ma DataProvider) hexIds(collection string, items ...map[string]interface{}) {
+
+	if !ma.isObjectIdCollection(collection) {
+		return
+	}
+	for _, item := range items {
+		if item != nil {
+			item[ID] = hexId(item[ID])
+		}
+	}
+}
+
+func hexId(id interface{}) interface{} {
+	if objectId, isObjectId := id.(bson.ObjectId); isObjectId {
+		return objectId.Hex()
+	}
+	return id
+}
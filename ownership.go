@@ -0,0 +1,138 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Extras of the Ownership interceptor.
+type OwnershipOptions struct {
+	// field of the documents that holds the owner, defaults to "createdBy"
+	Field string
+	// key of the requestscope that holds the authenticated user, defaults to "userId"
+	UserKey string
+	// key of the requestscope that holds the role, or the roles as []string,
+	// of the user. defaults to "role"
+	RoleKey string
+	// roles that can access all the documents, defaults to "admin"
+	AdminRoles []string
+}
+
+func (o OwnershipOptions) field() string {
+	if o.Field == "" {
+		return "createdBy"
+	}
+	return o.Field
+}
+
+func (o OwnershipOptions) userKey() string {
+	if o.UserKey == "" {
+		return "userId"
+	}
+	return o.UserKey
+}
+
+func (o OwnershipOptions) roleKey() string {
+	if o.RoleKey == "" {
+		return "role"
+	}
+	return o.RoleKey
+}
+
+func (o OwnershipOptions) isAdmin(rs requestscope.RequestScope) bool {
+
+	adminRoles := o.AdminRoles
+	if adminRoles == nil {
+		adminRoles = []string{"admin"}
+	}
+	for _, role := range scopeRoles(rs, o.roleKey()) {
+		if containsString(adminRoles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stamps the created documents with the authenticated user and restricts the
+// other requests to the documents the user created. Queries get the owner
+// added to their where filter or aggregation pipeline, and the requests on a
+// single document fail with 404 if it belongs to another user. Users with an
+// admin role can access all the documents, and create them on behalf of others.
+// The include parameter is rejected for the other users, since the related
+// documents are fetched by the ids in the document regardless of their owner.
+// Must be added to all methods before the execution.
+// Example Usage:
+// core.Interceptors.Add("/notes", methods.Any, interceptors.BEFORE_EXEC, mongoutil.Ownership, nil)
+// core.Interceptors.Add("/notes/{id}", methods.Any, interceptors.BEFORE_EXEC, mongoutil.Ownership, mongoutil.OwnershipOptions{AdminRoles: []string{"admin", "support"}})
+func Ownership(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	options, _ := extras.(OwnershipOptions)
+	field := options.field()
+
+	user := rs.Get(options.userKey())
+	if user == nil {
+		err = &utils.Error{
+			Code:    http.StatusUnauthorized,
+			Message: "Request does not have an authenticated user.",
+		}
+		return
+	}
+	isAdmin := options.isAdmin(rs)
+	if !isAdmin {
+		if err = checkNoIncludes(req); err != nil {
+			return
+		}
+	}
+	collection, id := splitResource(req.Res)
+	editedReq = req
+
+	// writes cannot give a document to another user
+	if value, hasField := req.Body[field]; hasField && !isAdmin && !reflect.DeepEqual(value, user) {
+		err = &utils.Error{
+			Code:    http.StatusForbidden,
+			Message: "Input cannot contain another user's '" + field + "'.",
+		}
+		return
+	}
+
+	if id != "" && !isAdmin {
+		document, getErr := db.Get(collection, id)
+		// a missing document fails the request itself, any other error must
+		// fail the check since the request would run without it
+		if getErr != nil {
+			if KindOf(getErr) != ErrNotFound {
+				err = getErr
+			}
+			return
+		}
+		if !reflect.DeepEqual(document[field], user) {
+			err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		}
+		return
+	}
+
+	switch strings.ToLower(req.Command) {
+	case methods.Post:
+		if _, hasField := req.Body[field]; hasField {
+			return
+		}
+		body := make(map[string]interface{}, len(req.Body)+1)
+		for k, v := range req.Body {
+			body[k] = v
+		}
+		body[field] = user
+		editedReq.Body = body
+
+	case methods.Get:
+		if id == "" && !isAdmin {
+			editedReq.Parameters, err = withFilter(req.Parameters, map[string]interface{}{field: user})
+		}
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package mongoutil
+
+import (
+	"encoding/json"
+)
+
+// Builds aggregation pipelines for the aggregate parameter of Query.
+// Example Usage:
+// parameters := mongoutil.NewPipeline().
+//
+//	Match(map[string]interface{}{"status": "paid"}).
+//	Group("$customerId", map[string]interface{}{"total": map[string]interface{}{"$sum": "$amount"}}).
+//	Sort("-total").
+//	Limit(10).
+//	Parameters()
+//
+// response, err := provider.Query("orders", parameters)
+type Pipeline struct {
+	stages []interface{}
+}
+
+func NewPipeline() *Pipeline {
+	return &Pipeline{stages: make([]interface{}, 0)}
+}
+
+func (p *Pipeline) Stage(name string, body interface{}) *Pipeline {
+	p.stages = append(p.stages, map[string]interface{}{name: body})
+	return p
+}
+
+func (p *Pipeline) Match(filter map[string]interface{}) *Pipeline {
+	return p.Stage("$match", filter)
+}
+
+// Groups by the id expression, e.g. "$country", with the given accumulators.
+func (p *Pipeline) Group(id interface{}, accumulators map[string]interface{}) *Pipeline {
+	group := map[string]interface{}{ID: id}
+	for k, v := range accumulators {
+		group[k] = v
+	}
+	return p.Stage("$group", group)
+}
+
+// Sorts by the field, prefixed with '-' for descending order. Only one field
+// is accepted since the aggregate parameter is decoded into maps, which don't
+// keep the order of the fields of a multi-field sort.
+func (p *Pipeline) Sort(field string) *Pipeline {
+	if len(field) > 0 && field[0] == '-' {
+		return p.Stage("$sort", map[string]interface{}{field[1:]: -1})
+	}
+	return p.Stage("$sort", map[string]interface{}{field: 1})
+}
+
+func (p *Pipeline) Project(projection map[string]interface{}) *Pipeline {
+	return p.Stage("$project", projection)
+}
+
+// $lookup is not in DefaultAllowedStages, so it must be added to AllowedStages.
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	return p.Stage("$lookup", map[string]interface{}{
+		"from":         from,
+		"localField":   localField,
+		"foreignField": foreignField,
+		"as":           as,
+	})
+}
+
+// Unwinds the array field, given without the '$' prefix.
+func (p *Pipeline) Unwind(field string) *Pipeline {
+	return p.Stage("$unwind", "$"+field)
+}
+
+func (p *Pipeline) Skip(n int) *Pipeline {
+	return p.Stage("$skip", n)
+}
+
+func (p *Pipeline) Limit(n int) *Pipeline {
+	return p.Stage("$limit", n)
+}
+
+func (p *Pipeline) Count(field string) *Pipeline {
+	return p.Stage("$count", field)
+}
+
+// Returns the stages of the pipeline.
+func (p *Pipeline) Build() []interface{} {
+	return p.stages
+}
+
+// Returns the pipeline as the aggregate parameter of Query.
+func (p *Pipeline) Parameters() map[string][]string {
+	encoded, _ := json.Marshal(p.stages)
+	return map[string][]string{
+		"aggregate": {string(encoded)},
+	}
+}
@@ -0,0 +1,134 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// read preferences of CollectionPolicy
+var readPreferences = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primaryPreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondaryPreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+// Overrides the settings of the operations on a collection. Zero values keep
+// the defaults of the operations.
+// Example Usage:
+//
+//	provider.CollectionPolicies = map[string]mongoutil.CollectionPolicy{
+//	    "events":    {SocketTimeout: time.Minute, DefaultLimit: 100, MaxLimit: 1000, ReadPreference: "secondaryPreferred"},
+//	    "countries": {SocketTimeout: 100 * time.Millisecond, Attempts: 2},
+//	}
+type CollectionPolicy struct {
+	SyncTimeout   time.Duration
+	SocketTimeout time.Duration
+
+	// number of times an operation is tried before it fails. defaults to 5
+	Attempts int
+
+	// limit of the queries without a limit parameter, and the greatest limit
+	// that a query can have. queries with a greater limit are capped
+	DefaultLimit int
+	MaxLimit     int
+
+	// one of primary, primaryPreferred, secondary, secondaryPreferred and
+	// nearest. only applied to the reads, writes always go to the primary
+	ReadPreference string
+
+	// index key that the queries and aggregations are pinned to when the
+	// planner picks the wrong index, e.g. []string{"status", "-createdAt"}.
+	// ShapeHints pins only the queries with the given shape hashes, which are
+	// logged with the slow operations, and overrides Hint for them. the metrics
+	// are labeled only with these shapes
+	Hint       []string
+	ShapeHints map[string][]string
+}
+
+func (ma DataProvider) checkPolicies() (err *utils.Error) {
+	for collection, policy := range ma.CollectionPolicies {
+		if _, isValid := readPreferences[policy.ReadPreference]; policy.ReadPreference != "" && !isValid {
+			err = &utils.Error{
+				Code:    http.StatusInternalServerError,
+				Message: "Read preference '" + policy.ReadPreference + "' of '" + collection + "' is not valid.",
+			}
+			return
+		}
+	}
+	return
+}
+
+// Copies the session with the timeouts and the read preference of the
+// collection. The given timeouts are used unless the policy overrides them.
+func (ma DataProvider) copySession(collection string, read bool, syncTimeout, socketTimeout time.Duration) (session *mgo.Session) {
+
+	policy := ma.CollectionPolicies[collection]
+	if policy.SyncTimeout > 0 {
+		syncTimeout = policy.SyncTimeout
+	}
+	if policy.SocketTimeout > 0 {
+		socketTimeout = policy.SocketTimeout
+	}
+
+	session = ma.session.Copy()
+	session.SetSyncTimeout(syncTimeout)
+	session.SetSocketTimeout(socketTimeout)
+	preference := policy.ReadPreference
+	if ma.readPreference != "" {
+		preference = ma.readPreference
+	}
+	if mode, hasMode := readPreferences[preference]; read && hasMode {
+		session.SetMode(mode, false)
+	}
+	ma.applyCausality(session, read)
+	return
+}
+
+// Returns the number of attempts of the operations on the collection.
+func (ma DataProvider) attempts(collection string) int {
+	if attempts := ma.CollectionPolicies[collection].Attempts; attempts > 0 {
+		return attempts
+	}
+	return 5
+}
+
+// Returns the index key that the query with the shape is pinned to.
+func (ma DataProvider) queryHint(collection, shape string) []string {
+
+	policy := ma.CollectionPolicies[collection]
+	if hint, hasHint := policy.ShapeHints[fingerprintHash(shape)]; hasHint {
+		return hint
+	}
+	return policy.Hint
+}
+
+// Converts an index key in mgo's notation to the document of the hint option.
+func hintDocument(key []string) (document bson.D) {
+	for _, field := range key {
+		order := 1
+		if strings.HasPrefix(field, "-") {
+			order = -1
+		}
+		document = append(document, bson.DocElem{Name: strings.TrimLeft(field, "+-"), Value: order})
+	}
+	return
+}
+
+// Applies the default and maximum limits of the collection to the query limit.
+func (ma DataProvider) policyLimit(collection string, limit int) int {
+
+	policy := ma.CollectionPolicies[collection]
+	if limit <= 0 && policy.DefaultLimit > 0 {
+		limit = policy.DefaultLimit
+	}
+	if policy.MaxLimit > 0 && (limit <= 0 || limit > policy.MaxLimit) {
+		limit = policy.MaxLimit
+	}
+	return limit
+}
@@ -0,0 +1,59 @@
+package mongoutil
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Collects operation counts and latencies labeled by operation, collection,
+// query shape and status. It must be registered to a prometheus registry to be exposed.
+// Example Usage:
+// provider.Prometheus = mongoutil.NewPrometheusCollector("myapp")
+// prometheus.MustRegister(provider.Prometheus)
+type PrometheusCollector struct {
+	operations *prometheus.CounterVec
+	durations  *prometheus.HistogramVec
+}
+
+func NewPrometheusCollector(namespace string) *PrometheusCollector {
+
+	labels := []string{"operation", "collection", "shape", "status"}
+	return &PrometheusCollector{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "mongo",
+			Name:      "operations_total",
+			Help:      "Number of database operations.",
+		}, labels),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "mongo",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of database operations.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, labels),
+	}
+}
+
+func (c *PrometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.operations.Describe(ch)
+	c.durations.Describe(ch)
+}
+
+func (c *PrometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	c.operations.Collect(ch)
+	c.durations.Collect(ch)
+}
+
+// The shape is the hash of the query fingerprint if it is declared in ShapeHints,
+// "other" for the other queries and empty for other operations.
+func (c *PrometheusCollector) ObserveOperation(operation, collection, shape string, duration time.Duration, failed bool) {
+
+	status := "success"
+	if failed {
+		status = "error"
+	}
+
+	c.operations.WithLabelValues(operation, collection, shape, status).Inc()
+	c.durations.WithLabelValues(operation, collection, shape, status).Observe(duration.Seconds())
+}
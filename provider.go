@@ -1,11 +1,12 @@
 package mongoutil
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
-	"github.com/rihtim/core/log"
+	"github.com/rihtim/core/requestscope"
 	"github.com/rihtim/core/utils"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"io"
@@ -22,8 +23,183 @@ type DataProvider struct {
 	Password     string
 	Collections  map[string]bool
 
-	session  *mgo.Session
-	dialInfo mgo.DialInfo
+	// maps collections to their duplicates during a migration. reads from
+	// these collections are compared with the duplicate and the lagging copy is healed
+	ReadRepair map[string]string
+
+	// pushes operation metrics to a StatsD/Datadog agent if set
+	StatsD *StatsDEmitter
+
+	// collects operation metrics to be scraped by prometheus if set
+	Prometheus *PrometheusCollector
+
+	// creates a span for every operation if set. the parent span
+	// is taken from the context given to WithContext
+	Tracer trace.Tracer
+
+	// Create returns the whole created document, including the fields set by
+	// the provider and the hooks, instead of only the id and the timestamps.
+	// WithFullDocument enables it per call
+	ReturnFullDocument bool
+
+	// document that Update returns, instead of only the updatedAt and the
+	// version. WithReturnDocument overrides it per call
+	ReturnDocument ReturnDocument
+
+	// receives the logs of the provider. CoreLogger is used if it is nil
+	Logger Logger
+
+	// levels and sampling of the frequent log events, e.g. LogEventRetry
+	LogPolicies map[string]LogPolicy
+
+	// fields whose values are replaced in the logged documents and query
+	// parameters, for all the collections and per collection.
+	// DefaultRedactedFields are used if RedactedFields is nil
+	RedactedFields           []string
+	CollectionRedactedFields map[string][]string
+
+	// receive the start and the end of every operation
+	Monitors []OperationMonitor
+
+	// operations taking longer than this are logged as warnings. disabled if zero
+	SlowOperationThreshold time.Duration
+
+	// collects the documents and bytes read and written per collection
+	// and caller to be reported by UsageReport
+	CostAccounting bool
+
+	// declared indexes and validators per collection. they are compared with
+	// the server at Connect and the differences are reported by Drift
+	Indexes    map[string][]mgo.Index
+	Validators map[string]bson.M
+
+	// relations of the collections, used to fetch related documents together
+	Relations map[string][]Relation
+
+	// collections whose documents are marked with deletedAt instead of being
+	// removed. marked documents are excluded from Get and Query
+	SoftDelete map[string]bool
+
+	// collections whose documents have a _version that is increased by every
+	// update. updates must contain the current version or they fail with 409
+	Versioning map[string]bool
+
+	// resolves the database of a tenant for WithTenant and ForRequest.
+	// the tenant is read from the requestscope key TenantKey ("tenantId" by default)
+	TenantDatabase func(tenant string) string
+	TenantKey      string
+
+	// encrypts the configured fields before writing and decrypts them after reading
+	Encryption *FieldEncryption
+
+	// restricts the operators that can be used in the where and aggregate parameters
+	Operators *OperatorPolicy
+
+	// stages that can be used in the aggregate parameter.
+	// DefaultAllowedStages are used if it is nil
+	AllowedStages []string
+
+	// aggregations are allowed to use disk for large sorts and groups unless
+	// this is set. the allowDiskUse query parameter overrides it per query
+	DisableDiskUse bool
+
+	// queries and aggregations running longer than this are killed by the
+	// server. the maxTimeMS query parameter can lower it per query
+	MaxQueryTime time.Duration
+
+	// representation of the timestamps written by the provider. unix seconds by default
+	TimestampMode TimestampMode
+
+	// removes the fields given as null to Update instead of storing null, and
+	// omits them on Create. NullFilter and MissingFilter tell the two apart
+	UnsetNulls bool
+
+	// names of the createdAt and updatedAt fields for all the collections
+	// and the overrides per collection. see TimestampFields
+	TimestampFields           TimestampFields
+	CollectionTimestampFields map[string]TimestampFields
+
+	// generates the ids of the created documents. ObjectIdGenerator is used if it is nil
+	IDGenerator IDGenerator
+
+	// collections whose _id is stored as ObjectId instead of its hex string, for
+	// interop with data written by other tools. the string ids given to the
+	// operations are converted and the ids in the responses are hex strings
+	ObjectIds map[string]bool
+
+	// limits of the nesting and the number of the clauses of the $and, $or
+	// and $nor operators in the query filters. see DefaultMaxFilterDepth
+	MaxFilterDepth   int
+	MaxFilterClauses int
+
+	// limits of the byte size and the nesting of the documents given to Create
+	// and Update. see DefaultMaxDocumentSize
+	MaxDocumentSize  int
+	MaxDocumentDepth int
+
+	// requires destructive operations to be confirmed if set
+	Safety *SafetyGuard
+
+	// collections whose documents get lastAccessedAt updated when they are
+	// read, mapped to the ratio of the reads that are recorded (0-1]
+	AccessTracking map[string]float64
+
+	// caches the documents read by Get for the collections in CacheTTL, for
+	// the given durations. the documents are removed from the cache when they
+	// are changed through the provider
+	Cache    Cache
+	CacheTTL map[string]time.Duration
+
+	// caches the results of the queries on the collections in QueryCacheTTL, for
+	// the given durations. any write to a collection invalidates all its queries
+	QueryCacheTTL map[string]time.Duration
+
+	// timeouts, attempts, limits and read preferences of the collections
+	CollectionPolicies map[string]CollectionPolicy
+
+	// accepts the readPreference parameter of Query if set. it should only be
+	// set for trusted callers, e.g. the analytics services, since the reads
+	// from the secondaries may be stale
+	AllowReadPreferenceParam bool
+
+	// row-level security rules merged into the queries of the collections,
+	// for the requester bound with WithRoles. roles are read from RoleKey
+	RowPolicies map[string]RowPolicy
+	RoleKey     string
+
+	// shard keys of the collections in mgo's index key notation, e.g.
+	// {"orders": {"customerId", "$hashed:_id"}}. the collections are sharded by
+	// Connect, creates must contain the shard key and updates must contain its
+	// unchanged values, so that mongos routes them to a single shard
+	ShardKeys map[string][]string
+
+	// types of the fields of the collections, e.g. {"orders": {"total": TypeInt, "customer": TypeObjectId}}.
+	// the values of the declared fields are converted to their types on Create
+	// and Update, so that "10" is stored as 10 and a hex string as an ObjectId
+	FieldTypes map[string]map[string]FieldType
+
+	// fields that hold the types of the documents of the collections storing many
+	// kinds of documents, e.g. {"vehicles": "type"}. the types can have their own
+	// schemas and hooks, and WithType restricts the provider to a type
+	Discriminators map[string]string
+
+	// decides whether a failed attempt is retried. IsRetryable is used if it is nil
+	RetryableError func(err error) bool
+
+	session      *mgo.Session
+	dialInfo     mgo.DialInfo
+	state        *providerState
+	ctx          context.Context
+	caller       string
+	confirmation string
+	tenant       string
+	causal       *causalSession
+
+	readPreference string
+	fullDocument   bool
+	returnDocument ReturnDocument
+	scope          *requestscope.RequestScope
+	entityType     string
 }
 
 func (ma *DataProvider) Init() (err *utils.Error) {
@@ -42,11 +218,44 @@ func (ma *DataProvider) Init() (err *utils.Error) {
 		Username: ma.Username,
 		Password: ma.Password,
 	}
+	if ma.state == nil {
+		ma.state = &providerState{session: ma.session}
+	}
+
+	if err = ma.checkPolicies(); err != nil {
+		return
+	}
+	if err = ma.checkFieldTypes(); err != nil {
+		return
+	}
+	if ma.Safety != nil {
+		ma.Safety.logger = ma.Logger
+	}
+	if ma.StatsD != nil {
+		ma.StatsD.logger = ma.Logger
+		if err = ma.StatsD.Init(); err != nil {
+			return
+		}
+	}
+	if ma.Encryption != nil {
+		ma.Encryption.logger = ma.Logger
+		if err = ma.Encryption.Init(); err != nil {
+			return
+		}
+	}
 	return
 }
 
 func (ma *DataProvider) Connect() (err *utils.Error) {
 
+	// connecting more than once must not leak the existing session
+	if ma.session != nil {
+		return
+	}
+
+	// pool usage is reported by Stats. sockets are only counted once it is enabled
+	mgo.SetStats(true)
+
 	var dialErr error
 	ma.session, dialErr = mgo.DialWithInfo(&ma.dialInfo)
 	if dialErr != nil {
@@ -55,16 +264,48 @@ func (ma *DataProvider) Connect() (err *utils.Error) {
 			Message: "Database connection failed.",
 		}
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason": dialErr.Error(),
 		}).Error("Mongo Error: Connection failed.")
 		return
 	}
+
+	if ma.state != nil {
+		ma.state.lifecycle.Lock()
+		ma.state.session = ma.session
+		ma.state.lifecycle.Unlock()
+
+		ma.state.mutex.Lock()
+		ma.state.connectedAt = time.Now()
+		ma.state.mutex.Unlock()
+	}
+
+	if ma.ShardKeys != nil {
+		if err = ma.ShardCollections(); err != nil {
+			return
+		}
+	}
+
+	// drift is only reported, it must not prevent the service from starting
+	if ma.Indexes != nil || ma.Validators != nil {
+		ma.CheckDrift()
+	}
 	return
 }
 
 func (ma DataProvider) Create(collection string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
 
+	op, err := ma.begin(OpCreate, collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
 	if ma.Collections != nil {
 		allowed, hasCollection := ma.Collections[collection]
 		if !allowed || !hasCollection {
@@ -75,183 +316,292 @@ func (ma DataProvider) Create(collection string, data map[string]interface{}) (r
 			return
 		}
 	}
+	if err = ma.checkDocumentLimits(data); err != nil {
+		return
+	}
 
-	sessionCopy := ma.session.Copy()
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
 	defer sessionCopy.Close()
-	sessionCopy.SetSyncTimeout(1 * time.Second)
-	sessionCopy.SetSocketTimeout(1 * time.Second)
 	connection := sessionCopy.DB(ma.Database).C(collection)
 
-	createdAt := float64(time.Now().Unix())
+	createdAt := ma.now()
 	if id, hasId := data[ID]; !hasId || id == "" {
-		id := bson.NewObjectId()
-		data[ID] = id.Hex()
+		data[ID] = ma.newId()
+	}
+	data[ID] = ma.storedId(collection, data[ID])
+	createdAtField, updatedAtField := ma.TimestampFieldNames(collection)
+	setField(data, createdAtField, createdAt)
+	setField(data, updatedAtField, createdAt)
+	if ma.isVersioned(collection) {
+		data[Version] = int64(1)
+	}
+
+	ma.dropNulls(data)
+	if err = ma.stampType(collection, data); err != nil {
+		return
+	}
+	if err = ma.runHooks(BeforeCreate, collection, hexId(data[ID]), data); err != nil {
+		return
+	}
+	if err = ma.coerceFields(collection, data); err != nil {
+		return
+	}
+	if err = ma.checkShardKey(collection, data); err != nil {
+		return
+	}
+	if err = ma.validateSchema(collection, data); err != nil {
+		return
+	}
+	// the document is copied before its fields are encrypted
+	var created map[string]interface{}
+	if ma.ReturnFullDocument || ma.fullDocument {
+		created = copyDocument(data)
+	}
+	if err = ma.Encryption.encrypt(collection, data); err != nil {
+		return
 	}
-	data[CreatedAt] = createdAt
-	data[UpdatedAt] = createdAt
 
-	insertError := retry(5, func() (err error) {
-		return connection.Insert(data)
+	// a duplicate id after a failed attempt means that the failed attempt inserted it
+	attempt := 0
+	insertError := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+		attempt++
+		err = connection.Insert(data)
+		if attempt > 1 && mgo.IsDup(err) && insertApplied(connection, data[ID]) {
+			err = nil
+		}
+		return
 	})
 
 	if insertError != nil {
-		err = &utils.Error{
-			Code:    http.StatusInternalServerError,
-			Message: insertError.Error(),
-		}
+		err = driverError(insertError, insertError.Error())
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason":     insertError.Error(),
 			"collection": collection,
-			"data":       data,
+			"data":       ma.redactDocument(collection, data),
 		}).Error("Mongo Error: Inserting item failed.")
 		return
 	}
 
+	ma.InvalidateQueries(collection)
+	op.countWritten(data)
+	ma.publishChange(ChangeEvent{Type: ChangeInsert, Collection: collection, ID: data[ID], After: data})
+	ma.runAfterHooks(AfterCreate, collection, hexId(data[ID]), data)
+
+	if created != nil {
+		response = created
+		ma.hexIds(collection, response)
+		ma.stringFields(collection, response)
+		return
+	}
+
 	response = map[string]interface{}{
-		ID:        data[ID],
-		CreatedAt: createdAt,
-		UpdatedAt: createdAt,
+		ID: hexId(data[ID]),
+	}
+	setField(response, createdAtField, createdAt)
+	setField(response, updatedAtField, createdAt)
+	if ma.isVersioned(collection) {
+		response[Version] = data[Version]
 	}
 	return
 }
 
 func (ma DataProvider) Get(collection string, id string) (response map[string]interface{}, err *utils.Error) {
 
-	sessionCopy := ma.session.Copy()
+	op, err := ma.begin(OpGet, collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, true, 1*time.Second, 300*time.Millisecond)
 	defer sessionCopy.Close()
-	sessionCopy.SetSyncTimeout(1 * time.Second)
-	sessionCopy.SetSocketTimeout(300 * time.Millisecond)
 	connection := sessionCopy.DB(ma.Database).C(collection)
 
+	filter, isScoped, err := ma.scopedIdFilter(collection, id)
+	if err != nil {
+		return
+	}
+
 	response = make(map[string]interface{})
 
-	getErr := retry(5, func() (err error) {
-		return connection.FindId(id).One(&response)
-	})
+	// the cached documents are shared by all the requesters, so they are not
+	// used when the scope of the requester restricts the document
+	cached, isCached := ma.cachedItem(collection, id)
+	isCached = isCached && !isScoped
+	generation := ma.itemGeneration(collection, id)
+	var getErr error
+	if isCached {
+		response = cached
+	} else {
+		getErr = ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			return connection.Find(filter).One(&response)
+		})
+	}
 
 	if getErr != nil {
 		if getErr == mgo.ErrNotFound {
-			err = &utils.Error{
-				Code:    http.StatusNotFound,
-				Message: "'" + collection + "' with id '" + id + "' not found.",
-			}
+			err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
 		} else {
-			err = &utils.Error{
-				Code:    http.StatusInternalServerError,
-				Message: "Getting '" + collection + "' with id '" + id + "' failed.",
-			}
+			err = driverError(getErr, "Getting '"+collection+"' with id '"+id+"' failed.")
 		}
 
 		response = nil
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason":     getErr.Error(),
 			"collection": collection,
 			"id":         id,
 		}).Error("Mongo Error: Getting item failed.")
 		return
 	}
+
+	op.countRead(response)
+	if !isCached {
+		ma.repairItem(collection, response)
+		ma.cacheItem(collection, id, response, generation)
+	}
+	ma.trackAccess(collection, []map[string]interface{}{response})
+
+	if err = ma.Encryption.decrypt(collection, response); err != nil {
+		response = nil
+		return
+	}
+	ma.hexIds(collection, response)
+	ma.stringFields(collection, response)
 	return
 }
 
 func (ma DataProvider) Query(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
 
-	sessionCopy := ma.session.Copy()
-	defer sessionCopy.Close()
-	sessionCopy.SetSyncTimeout(30 * time.Second)
-	sessionCopy.SetSocketTimeout(30 * time.Second)
-	connection := sessionCopy.DB(ma.Database).C(collection)
-
-	response = make(map[string]interface{})
-
-	if parameters["aggregate"] != nil && parameters["where"] != nil {
-		err = &utils.Error{
-			Code:    http.StatusBadRequest,
-			Message: "Where and aggregate parameters cannot be used at the same request.",
-		}
-
-		log.Error("Mongo Error: Where and aggregate parameters cannot be used at the same request.")
+	op, err := ma.begin(OpQuery, collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
 		return
 	}
 
-	var results []map[string]interface{}
-	var getErr error
+	sessionCopy := ma.copySession(collection, true, 30*time.Second, 30*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
 
-	whereParam, hasWhereParam, whereParamErr := extractJsonParameter(parameters, "where")
-	aggregateParam, hasAggregateParam, aggregateParamErr := extractJsonParameter(parameters, "aggregate")
-	sortParam, hasSortParam, sortParamErr := extractStringParameter(parameters, "sort")
-	limitParam, _, limitParamErr := extractIntParameter(parameters, "limit")
-	skipParam, _, skipParamErr := extractIntParameter(parameters, "skip")
+	response = make(map[string]interface{})
 
-	if aggregateParamErr != nil {
-		err = aggregateParamErr
-	}
-	if whereParamErr != nil {
-		err = whereParamErr
-	}
-	if sortParamErr != nil {
-		err = sortParamErr
-	}
-	if limitParamErr != nil {
-		err = limitParamErr
-	}
-	if skipParamErr != nil {
-		err = skipParamErr
-	}
+	q, err := ma.parseQuery(collection, parameters)
 	if err != nil {
 		return
 	}
+	op.shape = q.shape()
+	ma.routeRead(sessionCopy, q.readPreference)
 
-	if hasWhereParam && hasAggregateParam {
-		err = &utils.Error{
-			Code:    http.StatusInternalServerError,
-			Message: "Aggregation cannot be used with where parameter.",
-		}
+	if q.mode != "" {
+		response, err = ma.queryMode(sessionCopy, connection, collection, q)
 		return
 	}
 
-	if hasAggregateParam {
-		getErr = retry(5, func() (err error) {
-			return connection.Pipe(aggregateParam).AllowDiskUse().All(&results)
+	// the key is taken before the read, so results read before a write are
+	// cached under the generation that the write replaced
+	page, isCached, cacheKey := ma.cachedResults(collection, q.cacheParameters(parameters))
+	results := page.Results
+	var getErr error
+
+	if !isCached && q.hasAggregate {
+		pipeline := ma.excludeDeletedStages(collection, q.aggregate)
+		getErr = ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			return ma.aggregate(sessionCopy, collection, pipeline, q.aggregateOptions(), &results)
 		})
-	} else {
-		query := connection.Find(whereParam).Skip(skipParam).Limit(limitParam)
-		if hasSortParam {
-			query = query.Sort(sortParam)
+	} else if !isCached {
+		// one more document is read to tell whether there is a next page
+		next := q
+		if next.limit > 0 {
+			next.limit++
 		}
-		getErr = retry(5, func() (err error) {
+		query := ma.findQuery(connection, collection, next)
+		getErr = ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
 			return query.All(&results)
 		})
+		if getErr == nil && q.withCount {
+			getErr = ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+				page.Total, err = ma.countQuery(connection, collection, q).Count()
+				return
+			})
+		}
+		if q.limit > 0 && len(results) > q.limit {
+			results, page.HasMore = results[:q.limit], true
+		}
 	}
 
 	if getErr != nil {
-		err = &utils.Error{
-			Code:    http.StatusInternalServerError,
-			Message: "Querying items from database failed. Reason: " + getErr.Error(),
-		}
+		err = driverError(getErr, "Querying items from database failed. Reason: "+getErr.Error())
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason":     getErr.Error(),
 			"collection": collection,
-			"parameters": parameters,
+			"parameters": ma.redactParameters(collection, parameters),
+			"shape":      op.shape,
 		}).Error("Mongo Error: Querying items failed.")
 		return
 	}
 
+	op.countRead(results...)
+
+	// aggregation results are not whole documents so they cannot be compared
+	if !q.hasAggregate && !isCached {
+		ma.repairResults(collection, results)
+	}
+	if !q.hasAggregate {
+		ma.trackAccess(collection, results)
+	}
+	if !isCached {
+		page.Results = results
+		ma.cacheResults(collection, cacheKey, page)
+	}
+
+	if err = ma.Encryption.decrypt(collection, results...); err != nil {
+		return
+	}
+	// the ids are converted first, so the includes match them like in FetchGraph
+	ma.hexIds(collection, results...)
+	if len(q.include) > 0 && len(results) > 0 {
+		if err = ma.populate(sessionCopy, collection, results, q.include); err != nil {
+			return
+		}
+	}
+	ma.stringFields(collection, results...)
+
 	if results != nil {
 		response["results"] = results
 	} else {
 		response["results"] = make([]map[string]interface{}, 0)
 	}
+
+	// aggregations page themselves with their own stages
+	if !q.hasAggregate {
+		response[Skip] = q.skip
+		response[Limit] = q.limit
+		response[HasMore] = page.HasMore
+		if q.withCount {
+			response[Total] = page.Total
+		}
+	}
 	return
 }
 
 func (ma DataProvider) Update(collection string, id string, data map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
 
-	sessionCopy := ma.session.Copy()
+	op, err := ma.begin(OpUpdate, collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
 	defer sessionCopy.Close()
-	sessionCopy.SetSyncTimeout(1 * time.Second)
-	sessionCopy.SetSocketTimeout(1 * time.Second)
 	connection := sessionCopy.DB(ma.Database).C(collection)
 
 	if data == nil {
@@ -260,35 +610,152 @@ func (ma DataProvider) Update(collection string, id string, data map[string]inte
 			Message: "Request body cannot be empty for update requests.",
 		}
 
-		log.Error("Mongo Error: Request body cannot be empty for update requests.")
+		ma.logger().Error("Mongo Error: Request body cannot be empty for update requests.")
+		return
+	}
+	if err = ma.checkDocumentLimits(data); err != nil {
+		return
+	}
+	// embedded fields can be given in dot-notation, e.g. "address.city",
+	// to change them without replacing the whole embedded document
+	if err = checkUpdatePaths(data); err != nil {
 		return
 	}
 
-	data[UpdatedAt] = int32(time.Now().Unix())
+	var version int64
+	if ma.isVersioned(collection) {
+		if version, err = expectedVersion(data); err != nil {
+			return
+		}
+	}
 
-	objectToUpdate := make(map[string]interface{})
-	findErr := connection.FindId(id).One(&objectToUpdate)
-	if findErr != nil {
-		err = &utils.Error{
-			Code:    http.StatusNotFound,
-			Message: "Item not found.",
+	// in date mode the server sets updatedAt, otherwise it is
+	// set here so that the hooks and the validation can see it
+	_, updatedAtField := ma.TimestampFieldNames(collection)
+	if ma.TimestampMode != TimestampDate {
+		setField(data, updatedAtField, ma.now())
+	}
+
+	if err = ma.runHooks(BeforeUpdate, collection, id, data); err != nil {
+		return
+	}
+	if err = ma.coerceFields(collection, data); err != nil {
+		return
+	}
+
+	// the documents out of the scope of the requester are not found
+	filter, _, err := ma.scopedIdFilter(collection, id)
+	if err != nil {
+		return
+	}
+
+	// the update doesn't depend on the stored document, it is only
+	// read if the validation or the subscribers need it
+	var before map[string]interface{}
+	if ma.hasSchema(collection) || ma.hasSubscribers(collection) || ma.updatesType(collection, data) {
+		findErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			return connection.Find(filter).One(&before)
+		})
+		if findErr != nil {
+			if findErr == mgo.ErrNotFound {
+				err = newError(ErrNotFound, "Item not found.")
+			} else {
+				err = driverError(findErr, "Getting '"+collection+"' with id '"+id+"' failed.")
+			}
+			return
+		}
+		if err = ma.checkTypeChange(collection, before, data); err != nil {
+			return
 		}
+		if err = ma.validateUpdate(collection, before, data); err != nil {
+			return
+		}
+	}
+
+	// the id cannot be changed and the version is increased by the update itself
+	delete(data, ID)
+	delete(data, Version)
+
+	var shardKey bson.M
+	if shardKey, err = ma.extractShardKey(collection, data); err != nil {
 		return
 	}
 
-	// updating the fields that request body contains
-	for k, v := range data {
-		objectToUpdate[k] = v
+	if err = ma.Encryption.encrypt(collection, data); err != nil {
+		return
 	}
 
-	updateErr := connection.UpdateId(id, objectToUpdate)
-	if updateErr != nil {
+	// only the fields that the request body contains are set, so the
+	// concurrent updates of different fields don't overwrite each other
+	change := bson.M{}
+	set, unset := ma.splitNulls(data)
+	if len(set) > 0 {
+		change["$set"] = set
+	}
+	if len(unset) > 0 {
+		change["$unset"] = unset
+	}
+	if ma.TimestampMode == TimestampDate && updatedAtField != "" {
+		change["$currentDate"] = bson.M{updatedAtField: true}
+	}
+	if len(change) == 0 {
 		err = &utils.Error{
-			Code:    http.StatusInternalServerError,
-			Message: "Updating '" + collection + "' with id '" + id + "' failed.",
+			Code:    http.StatusBadRequest,
+			Message: "Update must contain at least one field.",
+		}
+		return
+	}
+
+	selector := bson.M{}
+	for field, value := range filter {
+		selector[field] = value
+	}
+	for field, value := range shardKey {
+		selector[field] = value
+	}
+	if ma.isVersioned(collection) {
+		selector[Version] = version
+		change["$inc"] = bson.M{Version: 1}
+	}
+
+	// the stored document is returned as it was before the update if the caller
+	// asked for it, and the document after the update is derived from it
+	returnDocument := ma.updateReturns()
+	var after map[string]interface{}
+	var info *mgo.ChangeInfo
+	// an applied attempt of a versioned update would make its retry fail with 409
+	retry := ma.retry
+	if ma.isVersioned(collection) {
+		retry = ma.retryWrite
+	}
+	updateErr := retry(sessionCopy, ma.attempts(collection), func() (err error) {
+		// the counts need the stored document to tell if the update modified it
+		if returnDocument != ReturnAfter {
+			info, err = connection.Find(selector).Apply(mgo.Change{Update: change}, &before)
+			return
+		}
+		info, err = connection.Find(selector).Apply(mgo.Change{Update: change, ReturnNew: true}, &after)
+		return
+	})
+	if updateErr == nil && returnDocument != ReturnAfter {
+		after = ma.updatedDocument(collection, before, data)
+	}
+
+	// a versioned update that doesn't match is either at another version or doesn't exist
+	if updateErr == mgo.ErrNotFound && ma.isVersioned(collection) {
+		if count, countErr := connection.Find(filter).Count(); countErr == nil && count > 0 {
+			err = versionConflict(collection, id, version)
+			return
 		}
+	}
+	if updateErr == mgo.ErrNotFound {
+		err = newError(ErrNotFound, "Item not found.")
+		return
+	}
+	if updateErr != nil {
+		err = driverError(updateErr, "Updating '"+collection+"' with id '"+id+"' failed.")
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason":     updateErr.Error(),
 			"collection": collection,
 			"id":         id,
@@ -296,45 +763,144 @@ func (ma DataProvider) Update(collection string, id string, data map[string]inte
 		return
 	}
 
+	ma.uncache(collection, id)
+	ma.InvalidateQueries(collection)
+	op.countRead(before)
+	op.countWritten(after)
+	ma.publishChange(ChangeEvent{Type: ChangeUpdate, Collection: collection, ID: id, Before: before, After: after})
+	ma.runAfterHooks(AfterUpdate, collection, id, after)
+
+	switch returnDocument {
+	case ReturnBefore:
+		response, err = ma.returnedDocument(collection, before)
+		return
+	case ReturnAfter:
+		response, err = ma.returnedDocument(collection, after)
+		return
+	}
+
+	// findAndModify reports the matched document as modified even if the update
+	// changed nothing, so it is found by comparing with the stored document.
+	// $currentDate and $inc always modify it
+	modified := 0
+	if change["$currentDate"] != nil || change["$inc"] != nil || changesDocument(before, set, unset) {
+		modified = 1
+	}
 	response = map[string]interface{}{
-		UpdatedAt: data[UpdatedAt],
+		MatchedCount:  info.Matched,
+		ModifiedCount: modified,
+	}
+	setField(response, updatedAtField, after[updatedAtField])
+	if ma.isVersioned(collection) {
+		response[Version] = after[Version]
 	}
 	return
 }
 
 func (ma DataProvider) Delete(collection string, id string) (response map[string]interface{}, err *utils.Error) {
 
-	sessionCopy := ma.session.Copy()
+	op, err := ma.begin(OpDelete, collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
 	defer sessionCopy.Close()
-	sessionCopy.SetSyncTimeout(1 * time.Second)
-	sessionCopy.SetSocketTimeout(1 * time.Second)
 	connection := sessionCopy.DB(ma.Database).C(collection)
 
-	removeErr := connection.RemoveId(id)
+	if err = ma.runHooks(BeforeDelete, collection, id, nil); err != nil {
+		return
+	}
+
+	// the documents out of the scope of the requester are not found
+	filter, _, err := ma.scopedIdFilter(collection, id)
+	if err != nil {
+		return
+	}
+
+	// the deleted document is only read if someone will receive it
+	// or the cascade rules need its fields
+	var before map[string]interface{}
+	if ma.hasSubscribers(collection) || ma.needsCascadeDocument(collection) {
+		connection.Find(filter).One(&before)
+	}
+
+	var removeErr error
+	var deletedAt interface{}
+	if ma.isSoftDelete(collection) {
+		deletedAt, removeErr = ma.softDelete(sessionCopy, collection, filter)
+	} else {
+		// not found after a failed attempt means that the failed attempt removed it
+		attempt := 0
+		removeErr = ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+			attempt++
+			err = connection.Remove(filter)
+			if attempt > 1 && err == mgo.ErrNotFound {
+				err = nil
+			}
+			return
+		})
+	}
+	if removeErr == mgo.ErrNotFound {
+		err = newError(ErrNotFound, "Item not found.")
+		return
+	}
 	if removeErr != nil {
-		err = &utils.Error{
-			Code:    http.StatusNotFound,
-			Message: "Updating '" + collection + "' with id '" + id + "' failed.",
-		}
+		err = driverError(removeErr, "Deleting '"+collection+"' with id '"+id+"' failed.")
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason":     removeErr.Error(),
 			"collection": collection,
 			"id":         id,
-		}).Error("Mongo Error: Updating item failed.")
+		}).Error("Mongo Error: Deleting item failed.")
+		return
+	}
+
+	ma.uncache(collection, id)
+	ma.InvalidateQueries(collection)
+	op.countWritten(before)
+	ma.publishChange(ChangeEvent{Type: ChangeDelete, Collection: collection, ID: id, Before: before})
+	ma.runAfterHooks(AfterDelete, collection, id, before)
+
+	if err = ma.cascadeDelete(sessionCopy, collection, ma.storedId(collection, id), before); err != nil {
+		return
+	}
+
+	response = map[string]interface{}{
+		DeletedCount: 1,
+	}
+	if ma.isSoftDelete(collection) {
+		response[DeletedAt] = deletedAt
 	}
 	return
 }
 
 func (ma DataProvider) CreateFile(data io.ReadCloser) (response map[string]interface{}, err *utils.Error) {
 
+	op, err := ma.begin(OpCreateFile, "fs")
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
 	if data == nil {
 		err = &utils.Error{
 			Code:    http.StatusBadRequest,
 			Message: "Request body cannot be empty for create file requests.",
 		}
 
-		log.Error("Mongo Error: Request body cannot be empty for create file requests.")
+		ma.logger().Error("Mongo Error: Request body cannot be empty for create file requests.")
 		return
 	}
 
@@ -342,6 +908,7 @@ func (ma DataProvider) CreateFile(data io.ReadCloser) (response map[string]inter
 	defer sessionCopy.Close()
 	sessionCopy.SetSyncTimeout(1 * time.Second)
 	sessionCopy.SetSocketTimeout(1 * time.Second)
+	ma.applyCausality(sessionCopy, false)
 
 	objectId := bson.NewObjectId()
 	now := time.Now()
@@ -354,7 +921,7 @@ func (ma DataProvider) CreateFile(data io.ReadCloser) (response map[string]inter
 			Message: "Creating file failed.",
 		}
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason": mongoErr.Error(),
 		}).Error("Creating file failed.")
 		return
@@ -364,14 +931,14 @@ func (ma DataProvider) CreateFile(data io.ReadCloser) (response map[string]inter
 	gridFile.SetUploadDate(now)
 
 	dec := base64.NewDecoder(base64.StdEncoding, data)
-	_, copyErr := io.Copy(gridFile, dec)
+	written, copyErr := io.Copy(gridFile, dec)
 	if copyErr != nil {
 		err = &utils.Error{
 			Code:    http.StatusInternalServerError,
 			Message: "Writing file failed.",
 		}
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason": copyErr.Error(),
 		}).Error("Mongo Error: Writing file failed.")
 		return
@@ -384,21 +951,30 @@ func (ma DataProvider) CreateFile(data io.ReadCloser) (response map[string]inter
 			Message: "Closing file failed.",
 		}
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason": closeErr.Error(),
 		}).Error("Mongo Error: Closing file failed.")
 		return
 	}
 
+	op.countFileWritten(written)
+
 	response = map[string]interface{}{
 		ID:        fileName,
-		CreatedAt: int32(now.Unix()),
+		CreatedAt: ma.TimestampMode.value(now),
 	}
 	return
 }
 
 func (ma DataProvider) GetFile(id string) (response []byte, err *utils.Error) {
 
+	op, err := ma.begin(OpGetFile, "fs")
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
 	sessionCopy := ma.session.Copy()
 	defer sessionCopy.Close()
 	sessionCopy.SetSyncTimeout(1 * time.Second)
@@ -407,22 +983,16 @@ func (ma DataProvider) GetFile(id string) (response []byte, err *utils.Error) {
 	file, mongoErr := sessionCopy.DB(ma.Database).GridFS("fs").OpenId(id)
 	if mongoErr != nil {
 		if mongoErr == mgo.ErrNotFound {
-			err = &utils.Error{
-				Code:    http.StatusNotFound,
-				Message: "File not found.",
-			}
+			err = newError(ErrNotFound, "File not found.")
 
-			log.WithFields(logrus.Fields{
+			ma.logger().WithFields(LogFields{
 				"reason": mongoErr.Error(),
 				"id":     id,
 			}).Error("Mongo Error: File not found.")
 		} else {
-			err = &utils.Error{
-				Code:    http.StatusInternalServerError,
-				Message: "Getting file failed.",
-			}
+			err = driverError(mongoErr, "Getting file failed.")
 
-			log.WithFields(logrus.Fields{
+			ma.logger().WithFields(LogFields{
 				"reason": mongoErr.Error(),
 				"id":     id,
 			}).Error("Mongo Error: Getting file failed.")
@@ -431,14 +1001,15 @@ func (ma DataProvider) GetFile(id string) (response []byte, err *utils.Error) {
 	}
 
 	response = make([]byte, file.Size())
-	_, printErr := file.Read(response)
+	read, printErr := file.Read(response)
+	op.countFileRead(int64(read))
 	if printErr != nil {
 		err = &utils.Error{
 			Code:    http.StatusInternalServerError,
 			Message: "Printing file failed. Reason: " + printErr.Error(),
 		}
 
-		log.WithFields(logrus.Fields{
+		ma.logger().WithFields(LogFields{
 			"reason": printErr.Error(),
 			"id":     id,
 		}).Error("Mongo Error: Printing file failed.")
@@ -455,10 +1026,7 @@ var extractJsonParameter = func(parameters map[string][]string, key string) (val
 	if hasParam {
 		parseErr := json.Unmarshal([]byte(paramArray[0]), &value)
 		if parseErr != nil {
-			err = &utils.Error{
-				Code:    http.StatusBadRequest,
-				Message: "Parsing " + key + " parameter failed. Reason: " + parseErr.Error(),
-			}
+			err = newError(ErrBadFilter, "Parsing "+key+" parameter failed. Reason: "+parseErr.Error())
 		}
 	}
 	return
@@ -493,6 +1061,35 @@ var extractStringParameter = func(parameters map[string][]string, key string) (v
 	return
 }
 
+var extractBoolParameter = func(parameters map[string][]string, key string) (value bool, hasParam bool, err *utils.Error) {
+
+	var paramArray []string
+	paramArray, hasParam = parameters[key]
+
+	if hasParam {
+		var paramValue interface{}
+		parseErr := json.Unmarshal([]byte(paramArray[0]), &paramValue)
+		if parseErr != nil {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Parsing " + key + " parameter failed. Reason: " + parseErr.Error(),
+			}
+		}
+
+		fieldType := reflect.TypeOf(paramValue)
+		if fieldType == nil || fieldType.Kind() != reflect.Bool {
+			value = false
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: "The key '" + key + "' must be a boolean.",
+			}
+			return
+		}
+		value = paramValue.(bool)
+	}
+	return
+}
+
 var extractIntParameter = func(parameters map[string][]string, key string) (value int, hasParam bool, err *utils.Error) {
 
 	var paramArray []string
@@ -522,7 +1119,7 @@ var extractIntParameter = func(parameters map[string][]string, key string) (valu
 	return
 }
 
-func retry(attempts int, function func() error) (err error) {
+func (ma DataProvider) retry(session *mgo.Session, attempts int, function func() error) (err error) {
 	for i := 0; ; i++ {
 		err = function()
 
@@ -531,8 +1128,8 @@ func retry(attempts int, function func() error) (err error) {
 			return
 		}
 
-		// no need to retry if the error is 'not found' error
-		if err == mgo.ErrNotFound {
+		// no need to retry if the error can never succeed, e.g. 'not found' or a duplicate key
+		if !ma.isRetryable(err) {
 			return
 		}
 
@@ -541,16 +1138,24 @@ func retry(attempts int, function func() error) (err error) {
 			break
 		}
 
-		log.WithFields(logrus.Fields{
+		// the sockets of a broken connection are never reused by mgo
+		// so they are released to let the next attempt dial again
+		if isConnectionError(err) {
+			ma.refreshSession(session)
+			fallbackToPrimary(session)
+		}
+
+		ma.countRetry()
+		ma.eventLogger(LogEventRetry, LogError).WithFields(LogFields{
 			"reason":  err.Error(),
 			"attempt": i + 1,
-		}).Error("Mongo Error: Attempt failed. Retrying.")
+		}).Log("Mongo Error: Attempt failed. Retrying.")
 	}
 
-	log.WithFields(logrus.Fields{
+	ma.eventLogger(LogEventRetryExhausted, LogError).WithFields(LogFields{
 		"reason":  err.Error(),
 		"attempt": attempts,
-	}).Error("Mongo Error: Last attempt failed. Not retrying.")
+	}).Log("Mongo Error: Last attempt failed. Not retrying.")
 
 	return err
 }
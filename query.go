@@ -0,0 +1,399 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// fields of the pagination metadata returned by Query along with the results
+const (
+	Total   = "total"
+	Skip    = "skip"
+	Limit   = "limit"
+	HasMore = "hasMore"
+)
+
+// values of the mode parameter of Query. count returns only the number of the
+// matching documents in "count", distinct returns the distinct values of the
+// field parameter among them in "results"
+// Example Usage:
+// ?mode="distinct"&field="country"&where={"active":true}
+const (
+	ModeCount    = "count"
+	ModeDistinct = "distinct"
+)
+
+// field of the response of the count mode
+const Count = "count"
+
+// parameters of a query after they are parsed and validated
+type queryOptions struct {
+	where        interface{}
+	hasWhere     bool
+	aggregate    interface{}
+	hasAggregate bool
+	sort         string
+	hasSort      bool
+	limit        int
+	skip         int
+	allowDiskUse bool
+	maxTime      time.Duration
+	include      includeTree
+	withCount    bool
+	mode         string
+	field        string
+	hint         []string
+
+	readPreference string
+	// filter added by the row policy and the type, which is part of the cache key
+	policy interface{}
+}
+
+func (q queryOptions) shape() string {
+	if q.hasAggregate {
+		return fingerprint(q.aggregate)
+	}
+	return fingerprint(q.where)
+}
+
+// Returns the parameters that the results of the query are cached by. The
+// results restricted by a row policy are only shared by the same filter.
+func (q queryOptions) cacheParameters(parameters map[string][]string) map[string][]string {
+
+	if q.policy == nil {
+		return parameters
+	}
+	cacheParameters := make(map[string][]string, len(parameters)+1)
+	for k, v := range parameters {
+		cacheParameters[k] = v
+	}
+	cacheParameters["$policy"] = []string{mustMarshal(q.policy)}
+	return cacheParameters
+}
+
+func (q queryOptions) aggregateOptions() aggregateOptions {
+	return aggregateOptions{allowDiskUse: q.allowDiskUse, maxTime: q.maxTime, hint: q.hint}
+}
+
+// Parses the query parameters and validates them against the configured policies.
+func (ma DataProvider) parseQuery(collection string, parameters map[string][]string) (q queryOptions, err *utils.Error) {
+
+	if parameters["aggregate"] != nil && parameters["where"] != nil {
+		err = newError(ErrBadFilter, "Where and aggregate parameters cannot be used at the same request.")
+
+		ma.logger().Error("Mongo Error: Where and aggregate parameters cannot be used at the same request.")
+		return
+	}
+
+	whereParam, hasWhereParam, whereParamErr := extractJsonParameter(parameters, "where")
+	aggregateParam, hasAggregateParam, aggregateParamErr := extractJsonParameter(parameters, "aggregate")
+	sortParam, hasSortParam, sortParamErr := extractStringParameter(parameters, "sort")
+	limitParam, _, limitParamErr := extractIntParameter(parameters, "limit")
+	skipParam, _, skipParamErr := extractIntParameter(parameters, "skip")
+	allowDiskUseParam, hasAllowDiskUseParam, allowDiskUseParamErr := extractBoolParameter(parameters, "allowDiskUse")
+	maxTimeParam, hasMaxTimeParam, maxTimeParamErr := extractIntParameter(parameters, "maxTimeMS")
+	includeParam, hasIncludeParam, includeParamErr := extractStringParameter(parameters, "include")
+	withCountParam, _, withCountParamErr := extractBoolParameter(parameters, "withCount")
+	friendlyParam, hasFriendlyParam, friendlyParamErr := friendlyFilter(parameters)
+	logicalParam, hasLogicalParam, logicalParamErr := logicalFilter(parameters)
+	modeParam, hasModeParam, modeParamErr := extractStringParameter(parameters, "mode")
+	fieldParam, _, fieldParamErr := extractStringParameter(parameters, "field")
+	readPreferenceParam, hasReadPreferenceParam, readPreferenceParamErr := extractStringParameter(parameters, "readPreference")
+
+	if aggregateParamErr != nil {
+		err = aggregateParamErr
+	}
+	if whereParamErr != nil {
+		err = whereParamErr
+	}
+	if sortParamErr != nil {
+		err = sortParamErr
+	}
+	if limitParamErr != nil {
+		err = limitParamErr
+	}
+	if skipParamErr != nil {
+		err = skipParamErr
+	}
+	if allowDiskUseParamErr != nil {
+		err = allowDiskUseParamErr
+	}
+	if maxTimeParamErr != nil {
+		err = maxTimeParamErr
+	}
+	if includeParamErr != nil {
+		err = includeParamErr
+	}
+	if withCountParamErr != nil {
+		err = withCountParamErr
+	}
+	if friendlyParamErr != nil {
+		err = friendlyParamErr
+	}
+	if logicalParamErr != nil {
+		err = logicalParamErr
+	}
+	if modeParamErr != nil {
+		err = modeParamErr
+	}
+	if fieldParamErr != nil {
+		err = fieldParamErr
+	}
+	if readPreferenceParamErr != nil {
+		err = readPreferenceParamErr
+	}
+	if err != nil {
+		return
+	}
+
+	if hasWhereParam && hasAggregateParam {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Aggregation cannot be used with where parameter.",
+		}
+		return
+	}
+
+	if (hasFriendlyParam || hasLogicalParam) && hasAggregateParam {
+		err = newError(ErrBadFilter, "Filter parameters cannot be used with aggregate parameter.")
+		return
+	}
+
+	// the filters are checked before they are combined, so the $and
+	// combining them doesn't count against the limits
+	if err = ma.checkLogical(whereParam); err != nil {
+		return
+	}
+	if err = ma.checkLogical(logicalParam); err != nil {
+		return
+	}
+	if hasFriendlyParam {
+		whereParam, hasWhereParam = combineFilters(whereParam, friendlyParam), true
+	}
+	if hasLogicalParam {
+		whereParam, hasWhereParam = combineFilters(whereParam, logicalParam), true
+	}
+
+	if err = checkFilterPaths(whereParam); err != nil {
+		return
+	}
+	ma.parseFilterValues(collection, whereParam)
+	if err = ma.Operators.checkFilter(whereParam); err != nil {
+		return
+	}
+	if err = ma.Operators.checkPipeline(aggregateParam); err != nil {
+		return
+	}
+	if err = ma.checkStages(aggregateParam); err != nil {
+		return
+	}
+
+	// the policy and the type filters are added after the checks since they are not given by the caller
+	policyFilter, hasPolicyFilter, err := ma.scopeFilter(collection)
+	if err != nil {
+		return
+	}
+	if hasPolicyFilter && hasAggregateParam {
+		stages, _ := aggregateParam.([]interface{})
+		aggregateParam = append([]interface{}{map[string]interface{}{"$match": policyFilter}}, stages...)
+	} else if hasPolicyFilter {
+		whereParam, hasWhereParam = combineFilters(whereParam, policyFilter), true
+	}
+
+	if hasModeParam {
+		if err = ma.checkMode(collection, modeParam, fieldParam, hasAggregateParam || hasIncludeParam); err != nil {
+			return
+		}
+	}
+
+	// aggregation results are not whole documents so the relations may not apply
+	if hasIncludeParam && hasAggregateParam {
+		err = newError(ErrBadFilter, "Include and aggregate parameters cannot be used at the same request.")
+		return
+	}
+	var include includeTree
+	if hasIncludeParam {
+		include = parseIncludes(strings.Split(includeParam, ","))
+		if err = ma.checkIncludes(collection, include); err != nil {
+			return
+		}
+	}
+
+	allowDiskUse := !ma.DisableDiskUse
+	if hasAllowDiskUseParam {
+		allowDiskUse = allowDiskUseParam
+	}
+
+	maxTime := ma.queryMaxTime(maxTimeParam, hasMaxTimeParam)
+
+	if hasReadPreferenceParam && !ma.AllowReadPreferenceParam {
+		err = &utils.Error{
+			Code:    http.StatusForbidden,
+			Message: "Read preference parameter is not allowed.",
+		}
+		return
+	}
+	if hasReadPreferenceParam {
+		if err = checkReadPreference(readPreferenceParam); err != nil {
+			return
+		}
+	}
+
+	q = queryOptions{
+		where:        whereParam,
+		hasWhere:     hasWhereParam,
+		aggregate:    aggregateParam,
+		hasAggregate: hasAggregateParam,
+		sort:         sortParam,
+		hasSort:      hasSortParam,
+		limit:        ma.policyLimit(collection, limitParam),
+		skip:         skipParam,
+		allowDiskUse: allowDiskUse,
+		maxTime:      maxTime,
+		include:      include,
+		withCount:    withCountParam,
+		mode:         modeParam,
+		field:        fieldParam,
+
+		readPreference: readPreferenceParam,
+	}
+	if hasPolicyFilter {
+		q.policy = policyFilter
+	}
+	q.hint = ma.queryHint(collection, q.shape())
+	return
+}
+
+// Returns the find query for the where, sort, limit and skip parameters.
+func (ma DataProvider) findQuery(connection *mgo.Collection, collection string, q queryOptions) *mgo.Query {
+
+	query := connection.Find(ma.excludeDeleted(collection, q.where)).Skip(q.skip).Limit(q.limit)
+	if q.maxTime > 0 {
+		query = query.SetMaxTime(q.maxTime)
+	}
+	if q.hasSort {
+		query = query.Sort(q.sort)
+	}
+	if len(q.hint) > 0 {
+		query = query.Hint(q.hint...)
+	}
+	return query
+}
+
+func (ma DataProvider) checkMode(collection, mode, field string, hasAggregateOrInclude bool) (err *utils.Error) {
+
+	if mode != ModeCount && mode != ModeDistinct {
+		err = newError(ErrBadFilter, "Query mode '"+mode+"' is not supported.")
+		return
+	}
+	if hasAggregateOrInclude {
+		err = newError(ErrBadFilter, "Mode parameter cannot be used with aggregate or include parameters.")
+		return
+	}
+	if mode == ModeDistinct && field == "" {
+		err = newError(ErrBadFilter, "Field parameter must be specified for distinct mode.")
+		return
+	}
+
+	// encrypted values are different for every document
+	if mode == ModeDistinct && ma.Encryption != nil && containsString(ma.Encryption.Fields[collection], field) {
+		err = newError(ErrBadFilter, "Distinct values of the encrypted field '"+field+"' cannot be queried.")
+	}
+	return
+}
+
+// Runs the query in the count or distinct mode.
+func (ma DataProvider) queryMode(session *mgo.Session, connection *mgo.Collection, collection string, q queryOptions) (response map[string]interface{}, err *utils.Error) {
+
+	var modeErr error
+	switch q.mode {
+	case ModeCount:
+		var count int
+		modeErr = ma.retry(session, ma.attempts(collection), func() (err error) {
+			count, err = ma.countQuery(connection, collection, q).Count()
+			return
+		})
+		response = map[string]interface{}{Count: count}
+	case ModeDistinct:
+		values := make([]interface{}, 0)
+		modeErr = ma.retry(session, ma.attempts(collection), func() (err error) {
+			return ma.countQuery(connection, collection, q).Distinct(q.field, &values)
+		})
+		response = map[string]interface{}{List: values}
+	}
+
+	if modeErr != nil {
+		response = nil
+		err = driverError(modeErr, "Querying items from database failed. Reason: "+modeErr.Error())
+
+		ma.logger().WithFields(LogFields{
+			"reason":     modeErr.Error(),
+			"collection": collection,
+			"mode":       q.mode,
+		}).Error("Mongo Error: Querying items failed.")
+	}
+	return
+}
+
+// Returns the query counting the documents that match the where parameter,
+// regardless of skip and limit. Also used for the distinct values.
+func (ma DataProvider) countQuery(connection *mgo.Collection, collection string, q queryOptions) *mgo.Query {
+
+	query := connection.Find(ma.excludeDeleted(collection, q.where))
+	if q.maxTime > 0 {
+		query = query.SetMaxTime(q.maxTime)
+	}
+	return query
+}
+
+// Returns the query plan that the server picks for the query parameters,
+// to find out the queries missing an index. Query plans reveal the indexes
+// and the collection statistics so it must not be exposed to every client.
+func (ma DataProvider) Explain(collection string, parameters map[string][]string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("explain", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, true, 5*time.Second, 30*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
+
+	q, err := ma.parseQuery(collection, parameters)
+	if err != nil {
+		return
+	}
+	op.shape = q.shape()
+
+	var explainErr error
+	response = make(map[string]interface{})
+	if q.hasAggregate {
+		command := bson.D{
+			{Name: "aggregate", Value: collection},
+			{Name: "pipeline", Value: ma.excludeDeletedStages(collection, q.aggregate)},
+			{Name: "explain", Value: true},
+		}
+		explainErr = sessionCopy.DB(ma.Database).Run(command, &response)
+	} else {
+		explainErr = ma.findQuery(connection, collection, q).Explain(&response)
+	}
+
+	if explainErr != nil {
+		err = driverError(explainErr, "Explaining query failed. Reason: "+explainErr.Error())
+		response = nil
+
+		ma.logger().WithFields(LogFields{
+			"reason":     explainErr.Error(),
+			"collection": collection,
+			"shape":      op.shape,
+		}).Error("Mongo Error: Explaining query failed.")
+	}
+	return
+}
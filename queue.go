@@ -0,0 +1,181 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// collection that keeps the jobs of all the queues
+var QueueCollection = "jobs"
+
+// A job claimed by Dequeue. It must be acknowledged with Ack before its lease
+// ends, otherwise it is delivered again, so the jobs are processed at least once.
+type Job struct {
+	ID       string                 `bson:"_id" json:"_id"`
+	Queue    string                 `bson:"queue" json:"queue"`
+	Payload  map[string]interface{} `bson:"payload" json:"payload"`
+	Attempts int                    `bson:"attempts" json:"attempts"`
+
+	// the claim of the job, which is replaced when the job is claimed again
+	Lease       string    `bson:"lease" json:"lease"`
+	LeasedUntil time.Time `bson:"leasedUntil" json:"leasedUntil"`
+}
+
+// Adds a job to the queue and returns its id.
+// Example Usage:
+// id, err := provider.Enqueue("emails", map[string]interface{}{"to": email, "template": "welcome"})
+func (ma DataProvider) Enqueue(queue string, payload map[string]interface{}) (id string, err *utils.Error) {
+
+	op, err := ma.begin("enqueue", QueueCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(QueueCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(QueueCollection)
+
+	if err = ma.ensureIndexOnce(connection, mgo.Index{Key: []string{"queue", "leasedUntil", "enqueuedAt"}}); err != nil {
+		return
+	}
+
+	// a job that is never leased is available from the start
+	job := bson.M{
+		ID:            bson.NewObjectId().Hex(),
+		"queue":       queue,
+		"payload":     payload,
+		"attempts":    0,
+		"enqueuedAt":  time.Now(),
+		"leasedUntil": time.Time{},
+	}
+	insertErr := ma.retry(sessionCopy, ma.attempts(QueueCollection), func() error {
+		return connection.Insert(job)
+	})
+	if insertErr != nil {
+		err = driverError(insertErr, "Enqueuing job to '"+queue+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": insertErr.Error(),
+			"queue":  queue,
+		}).Error("Mongo Error: Enqueuing job failed.")
+		return
+	}
+
+	op.countWritten(job)
+	id = job[ID].(string)
+	return
+}
+
+// Claims the oldest available job of the queue for the lease duration. Jobs
+// whose lease ended without an Ack are available again. Returns a nil job if
+// the queue is empty.
+// Example Usage:
+//
+//	job, err := provider.Dequeue("emails", time.Minute)
+//	if job != nil {
+//	    send(job.Payload)
+//	    provider.Ack(job)
+//	}
+func (ma DataProvider) Dequeue(queue string, lease time.Duration) (job *Job, err *utils.Error) {
+
+	op, err := ma.begin("dequeue", QueueCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(QueueCollection, false, 1*time.Second, 5*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(QueueCollection)
+
+	now := time.Now()
+	change := mgo.Change{
+		Update: bson.M{
+			"$set": bson.M{"lease": bson.NewObjectId().Hex(), "leasedUntil": now.Add(lease)},
+			"$inc": bson.M{"attempts": 1},
+		},
+		ReturnNew: true,
+	}
+
+	claimed := &Job{}
+	claimErr := ma.retry(sessionCopy, ma.attempts(QueueCollection), func() (err error) {
+		_, err = connection.Find(bson.M{"queue": queue, "leasedUntil": bson.M{"$lte": now}}).
+			Sort("enqueuedAt").
+			Apply(change, claimed)
+		return
+	})
+	if claimErr == mgo.ErrNotFound {
+		return
+	}
+	if claimErr != nil {
+		err = driverError(claimErr, "Dequeuing job from '"+queue+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": claimErr.Error(),
+			"queue":  queue,
+		}).Error("Mongo Error: Dequeuing job failed.")
+		return
+	}
+
+	if claimed.Attempts > 1 {
+		ma.logger().WithFields(LogFields{
+			"queue":    queue,
+			"id":       claimed.ID,
+			"attempts": claimed.Attempts,
+		}).Warning("Mongo Warning: Redelivering job whose lease ended.")
+	}
+
+	job = claimed
+	return
+}
+
+// Removes the finished job. Returns 409 if the lease of the job ended and it
+// was claimed again, in which case the job will also be processed by the new claimer.
+func (ma DataProvider) Ack(job *Job) (err *utils.Error) {
+
+	op, err := ma.begin("ack", QueueCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(QueueCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(QueueCollection)
+
+	ackErr := ma.retry(sessionCopy, ma.attempts(QueueCollection), func() error {
+		return connection.Remove(bson.M{ID: job.ID, "lease": job.Lease})
+	})
+	if ackErr == mgo.ErrNotFound {
+		err = newError(ErrConflict, "Lease of job '"+job.ID+"' ended before it was acknowledged.")
+		return
+	}
+	if ackErr != nil {
+		err = driverError(ackErr, "Acknowledging job '"+job.ID+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": ackErr.Error(),
+			"queue":  job.Queue,
+			"id":     job.ID,
+		}).Error("Mongo Error: Acknowledging job failed.")
+	}
+	return
+}
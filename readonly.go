@@ -0,0 +1,45 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"sync/atomic"
+)
+
+// Turns the read-only mode on or off, for maintenance windows, migrations or
+// when the provider points at an analytics replica. In read-only mode the
+// writes fail with 503 and the background writes of read repair and access
+// tracking are skipped. It affects all the copies of the provider.
+// Example Usage:
+// provider.SetReadOnly(true)
+// defer provider.SetReadOnly(false)
+func (ma *DataProvider) SetReadOnly(readOnly bool) {
+
+	if ma.state == nil {
+		ma.state = &providerState{session: ma.session}
+	}
+
+	var value int32
+	if readOnly {
+		value = 1
+	}
+	if atomic.SwapInt32(&ma.state.readOnly, value) == value {
+		return
+	}
+	if readOnly {
+		ma.logger().Warning("Mongo Warning: Read-only mode is turned on.")
+	} else {
+		ma.logger().Warning("Mongo Warning: Read-only mode is turned off.")
+	}
+}
+
+func (ma DataProvider) IsReadOnly() bool {
+	return ma.state != nil && atomic.LoadInt32(&ma.state.readOnly) == 1
+}
+
+// Returns 503 if the provider is in read-only mode.
+func (ma DataProvider) checkWritable() (err *utils.Error) {
+	if ma.IsReadOnly() {
+		err = newError(ErrReadOnly, readOnlyMessage)
+	}
+	return
+}
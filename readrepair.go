@@ -0,0 +1,149 @@
+package mongoutil
+
+import (
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"time"
+)
+
+// Compares the document read from the collection with its copy in the
+// duplicate collection configured in ReadRepair. If the copies diverge the
+// difference is logged and the lagging copy is overwritten by the newer one.
+// Failures are only logged since the read itself already succeeded.
+func (ma DataProvider) repairItem(collection string, item map[string]interface{}) {
+
+	duplicate, hasDuplicate := ma.ReadRepair[collection]
+	if !hasDuplicate || item == nil || ma.IsReadOnly() {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(1 * time.Second)
+
+	ma.repairItems(sessionCopy, collection, duplicate, []map[string]interface{}{item})
+}
+
+// Same as repairItem but fetches all the copies with a single query.
+func (ma DataProvider) repairResults(collection string, results []map[string]interface{}) {
+
+	duplicate, hasDuplicate := ma.ReadRepair[collection]
+	if !hasDuplicate || len(results) == 0 || ma.IsReadOnly() {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	ma.repairItems(sessionCopy, collection, duplicate, results)
+}
+
+func (ma DataProvider) repairItems(session *mgo.Session, collection, duplicate string, items []map[string]interface{}) {
+
+	ids := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if id, hasId := item[ID]; hasId {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	var copies []map[string]interface{}
+	findErr := session.DB(ma.Database).C(duplicate).Find(bson.M{ID: bson.M{"$in": ids}}).All(&copies)
+	if findErr != nil {
+		ma.logger().WithFields(LogFields{
+			"reason":     findErr.Error(),
+			"collection": duplicate,
+		}).Error("Mongo Error: Reading duplicate items for read repair failed.")
+		return
+	}
+
+	_, updatedAtField := ma.TimestampFieldNames(collection)
+	copiesById := make(map[interface{}]map[string]interface{}, len(copies))
+	for _, c := range copies {
+		copiesById[c[ID]] = c
+	}
+
+	for _, item := range items {
+		id, hasId := item[ID]
+		if !hasId {
+			continue
+		}
+
+		diff := diffFields(item, copiesById[id])
+		if len(diff) == 0 {
+			continue
+		}
+
+		// the copy with the greater updatedAt is accepted as the correct one
+		source, target, targetCollection := item, copiesById[id], duplicate
+		if target != nil && TimestampTime(target[updatedAtField]).After(TimestampTime(source[updatedAtField])) {
+			source, target, targetCollection = target, item, collection
+		}
+
+		loggedDiff := make(map[string]interface{}, len(diff))
+		for field, values := range diff {
+			loggedDiff[field] = values
+		}
+		ma.logger().WithFields(LogFields{
+			"collection": collection,
+			"duplicate":  duplicate,
+			"id":         id,
+			"diff":       ma.redactDocument(collection, loggedDiff),
+			"healed":     targetCollection,
+		}).Warning("Mongo Warning: Duplicate collections diverged. Healing the lagging copy.")
+
+		_, upsertErr := session.DB(ma.Database).C(targetCollection).UpsertId(id, source)
+		ma.uncache(targetCollection, fmt.Sprint(hexId(id)))
+		if upsertErr != nil {
+			ma.logger().WithFields(LogFields{
+				"reason":     upsertErr.Error(),
+				"collection": targetCollection,
+				"id":         id,
+			}).Error("Mongo Error: Healing item failed.")
+		}
+	}
+}
+
+// Returns the fields whose values differ between the two documents as
+// field -> [first value, second value]. A missing document differs in all fields.
+func diffFields(first, second map[string]interface{}) (diff map[string][]interface{}) {
+
+	diff = make(map[string][]interface{})
+	for k, v := range first {
+		other, hasField := second[k]
+		if !hasField || !reflect.DeepEqual(v, other) {
+			diff[k] = []interface{}{v, other}
+		}
+	}
+	for k, v := range second {
+		if _, hasField := first[k]; !hasField {
+			diff[k] = []interface{}{nil, v}
+		}
+	}
+	return
+}
+
+// Converts the numeric values that the timestamps may be stored as to float64.
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
@@ -0,0 +1,45 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+)
+
+// Returns a copy of the provider whose reads use the read preference, e.g.
+// "secondaryPreferred" or "nearest", overriding the policies of the collections.
+// secondaryPreferred and nearest fall back to the primary when no secondary is
+// available, and so does "secondary" after its attempt fails. Reads following
+// the writes of a causally consistent request still go to the primary.
+// Unknown preferences are ignored.
+// Example Usage:
+// report, err := provider.WithReadPreference("secondaryPreferred").Query("orders", parameters)
+func (ma DataProvider) WithReadPreference(preference string) *DataProvider {
+	ma.readPreference = preference
+	return &ma
+}
+
+func checkReadPreference(preference string) (err *utils.Error) {
+	if _, isValid := readPreferences[preference]; !isValid {
+		err = newError(ErrBadFilter, "Read preference '"+preference+"' is not valid.")
+	}
+	return
+}
+
+// Sets the read preference of the session unless it is empty.
+func (ma DataProvider) routeRead(session *mgo.Session, preference string) {
+
+	mode, hasMode := readPreferences[preference]
+	if !hasMode {
+		return
+	}
+	session.SetMode(mode, false)
+	ma.applyCausality(session, true)
+}
+
+// Lets the reads requiring a secondary fall back to the primary when no
+// secondary is reachable.
+func fallbackToPrimary(session *mgo.Session) {
+	if session.Mode() == mgo.Secondary {
+		session.SetMode(mgo.SecondaryPreferred, false)
+	}
+}
@@ -0,0 +1,34 @@
+package mongoutil
+
+import (
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"runtime/debug"
+)
+
+// Converts a panic raised by the driver into an internal server error and
+// refreshes the session, since mgo panics when a closed session is used.
+// Must be deferred at the beginning of the operation, after track:
+// defer ma.recoverPanic(op, &err)
+func (ma DataProvider) recoverPanic(op *operation, err **utils.Error) {
+
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+
+	*err = &utils.Error{
+		Code:    http.StatusInternalServerError,
+		Message: "Database operation failed unexpectedly.",
+	}
+
+	ma.logger().WithFields(LogFields{
+		"reason":     fmt.Sprint(recovered),
+		"operation":  op.name,
+		"collection": op.collection,
+		"stack":      string(debug.Stack()),
+	}).Error("Mongo Error: Recovered from panic.")
+
+	ma.refreshSession(nil)
+}
@@ -0,0 +1,122 @@
+package mongoutil
+
+import (
+	"encoding/json"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// replaces the values of the redacted fields in the logs
+const redactedValue = "[REDACTED]"
+
+// fields that are redacted in the logs of all the collections if RedactedFields is nil
+var DefaultRedactedFields = []string{"password", "token", "secret", "apiKey", "accessToken", "refreshToken"}
+
+// Returns true if the field is redacted in the logs of the collection. Fields
+// are matched case-insensitively at any depth of the documents, including the
+// dotted paths and the flattened filter parameters, e.g. "user.password[ne]".
+func (ma DataProvider) isRedacted(collection, field string) bool {
+
+	if match := friendlyParameter.FindStringSubmatch(field); match != nil {
+		field = match[1]
+	}
+	if dot := strings.LastIndex(field, "."); dot >= 0 {
+		field = field[dot+1:]
+	}
+
+	global := ma.RedactedFields
+	if global == nil {
+		global = DefaultRedactedFields
+	}
+	for _, fields := range [][]string{global, ma.CollectionRedactedFields[collection]} {
+		for _, redacted := range fields {
+			if strings.EqualFold(field, redacted) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns a copy of the document to be logged, with the values of the redacted fields replaced.
+func (ma DataProvider) redactDocument(collection string, document map[string]interface{}) map[string]interface{} {
+
+	if document == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(document))
+	for k, v := range document {
+		if ma.isRedacted(collection, k) {
+			redacted[k] = redactedValue
+			continue
+		}
+		redacted[k] = ma.redactValue(collection, v)
+	}
+	return redacted
+}
+
+func (ma DataProvider) redactValue(collection string, value interface{}) interface{} {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return ma.redactDocument(collection, v)
+	case bson.M:
+		return ma.redactDocument(collection, v)
+	case bson.D:
+		redacted := make(bson.D, len(v))
+		for i, element := range v {
+			redacted[i] = bson.DocElem{Name: element.Name, Value: redactedValue}
+			if !ma.isRedacted(collection, element.Name) {
+				redacted[i].Value = ma.redactValue(collection, element.Value)
+			}
+		}
+		return redacted
+	case []map[string]interface{}:
+		redacted := make([]map[string]interface{}, len(v))
+		for i, element := range v {
+			redacted[i] = ma.redactDocument(collection, element)
+		}
+		return redacted
+	case []bson.M:
+		redacted := make([]map[string]interface{}, len(v))
+		for i, element := range v {
+			redacted[i] = ma.redactDocument(collection, element)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, element := range v {
+			redacted[i] = ma.redactValue(collection, element)
+		}
+		return redacted
+	}
+	return value
+}
+
+// Returns a copy of the query parameters to be logged. The json parameters,
+// e.g. where, are redacted as documents.
+func (ma DataProvider) redactParameters(collection string, parameters map[string][]string) map[string][]string {
+
+	redacted := make(map[string][]string, len(parameters))
+	for key, values := range parameters {
+		if ma.isRedacted(collection, key) {
+			redacted[key] = []string{redactedValue}
+			continue
+		}
+
+		redactedValues := make([]string, len(values))
+		for i, value := range values {
+			redactedValues[i] = value
+
+			var decoded interface{}
+			if json.Unmarshal([]byte(value), &decoded) != nil {
+				continue
+			}
+			if encoded, marshalErr := json.Marshal(ma.redactValue(collection, decoded)); marshalErr == nil {
+				redactedValues[i] = string(encoded)
+			}
+		}
+		redacted[key] = redactedValues
+	}
+	return redacted
+}
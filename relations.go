@@ -0,0 +1,293 @@
+package mongoutil
+
+import (
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Declares that the documents of a collection refer to the documents of
+// another collection. The related documents are the ones whose ForeignField
+// equals the LocalField of the document. If the LocalField holds a list, any
+// of its values matches. Many-to-many relations are declared with a Join
+// collection whose documents pair the LocalField value in JoinLocalField with
+// the ForeignField value in JoinForeignField.
+// Example Usage:
+//
+//	provider.Relations = map[string][]mongoutil.Relation{
+//	    "posts": {
+//	        {Name: "author", Collection: "users", LocalField: "authorId"},
+//	        {Name: "comments", Collection: "comments", LocalField: "_id", ForeignField: "postId", Many: true, OnDelete: mongoutil.CascadeDelete},
+//	        {Name: "tags", Collection: "tags", LocalField: "_id", Many: true, Join: "postTags", JoinLocalField: "postId", JoinForeignField: "tagId"},
+//	    },
+//	}
+type Relation struct {
+	// key that the related documents are returned in
+	Name       string
+	Collection string
+	LocalField string
+	// defaults to _id
+	ForeignField string
+	// returns a list of documents instead of a single one
+	Many bool
+
+	Join             string
+	JoinLocalField   string
+	JoinForeignField string
+
+	// what happens to the related documents when the document is deleted
+	// through Delete. see CascadeDelete and CascadeNullify
+	OnDelete string
+}
+
+func (r Relation) foreignField() string {
+	if r.ForeignField == "" {
+		return ID
+	}
+	return r.ForeignField
+}
+
+func (ma DataProvider) relation(collection, name string) (relation Relation, hasRelation bool) {
+	for _, r := range ma.Relations[collection] {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return
+}
+
+// Gets the document with its related documents nested in it. Includes are
+// relation names, nested relations are separated with dots, e.g.
+// FetchGraph("posts", id, []string{"author", "comments.author"})
+// The related documents are fetched with one query per relation and level.
+func (ma DataProvider) FetchGraph(collection, id string, include []string) (response map[string]interface{}, err *utils.Error) {
+
+	response, err = ma.Get(collection, id)
+	if err != nil {
+		return
+	}
+
+	op, err := ma.begin("fetchGraph", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+
+	// the document itself is restricted like the related documents
+	filter, err := ma.scopedFilter(collection, ma.idFilter(collection, id))
+	if err != nil {
+		response = nil
+		return
+	}
+	if count, countErr := sessionCopy.DB(ma.Database).C(collection).Find(filter).Count(); countErr != nil || count == 0 {
+		response = nil
+		err = newError(ErrNotFound, "Item not found.")
+		return
+	}
+
+	err = ma.populate(sessionCopy, collection, []map[string]interface{}{response}, parseIncludes(include))
+	if err != nil {
+		response = nil
+	}
+	return
+}
+
+// Returns 400 if any of the includes is not a declared relation, so the
+// query is rejected before it is run.
+func (ma DataProvider) checkIncludes(collection string, tree includeTree) (err *utils.Error) {
+	for name, children := range tree {
+		relation, hasRelation := ma.relation(collection, name)
+		if !hasRelation {
+			err = newError(ErrBadFilter, "'"+collection+"' has no relation named '"+name+"'.")
+			return
+		}
+		if err = ma.checkIncludes(relation.Collection, children); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// include tree: relation name -> nested includes
+type includeTree map[string]includeTree
+
+func parseIncludes(include []string) (tree includeTree) {
+
+	tree = make(includeTree)
+	for _, path := range include {
+		node := tree
+		for _, name := range strings.Split(path, ".") {
+			if name == "" {
+				continue
+			}
+			if node[name] == nil {
+				node[name] = make(includeTree)
+			}
+			node = node[name]
+		}
+	}
+	return
+}
+
+func (ma DataProvider) populate(session *mgo.Session, collection string, documents []map[string]interface{}, tree includeTree) (err *utils.Error) {
+
+	for name, children := range tree {
+		relation, hasRelation := ma.relation(collection, name)
+		if !hasRelation {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: "'" + collection + "' has no relation named '" + name + "'.",
+			}
+			return
+		}
+
+		var keys func(map[string]interface{}) []interface{}
+		if keys, err = ma.relationKeys(session, relation, documents); err != nil {
+			return
+		}
+
+		values := make([]interface{}, 0, len(documents))
+		for _, document := range documents {
+			values = append(values, keys(document)...)
+		}
+		if relation.foreignField() == ID {
+			for i, value := range values {
+				values[i] = ma.storedId(relation.Collection, value)
+			}
+		}
+
+		var related []map[string]interface{}
+		if len(values) > 0 {
+			// the related documents are restricted like the queries of their collection
+			var filter bson.M
+			if filter, err = ma.scopedFilter(relation.Collection, bson.M{relation.foreignField(): bson.M{"$in": values}}); err != nil {
+				return
+			}
+			findErr := ma.retry(session, 5, func() (err error) {
+				return session.DB(ma.Database).C(relation.Collection).Find(ma.excludeDeleted(relation.Collection, filter)).All(&related)
+			})
+			if findErr != nil {
+				err = &utils.Error{
+					Code:    http.StatusInternalServerError,
+					Message: "Getting '" + relation.Collection + "' related to '" + collection + "' failed.",
+				}
+
+				ma.logger().WithFields(LogFields{
+					"reason":     findErr.Error(),
+					"collection": relation.Collection,
+					"relation":   name,
+				}).Error("Mongo Error: Getting related items failed.")
+				return
+			}
+		}
+
+		if err = ma.Encryption.decrypt(relation.Collection, related...); err != nil {
+			return
+		}
+		ma.hexIds(relation.Collection, related...)
+		ma.stringFields(relation.Collection, related...)
+
+		relatedByValue := make(map[string][]map[string]interface{})
+		for _, r := range related {
+			for _, value := range relationValues(r[relation.foreignField()]) {
+				key := fmt.Sprint(value)
+				relatedByValue[key] = append(relatedByValue[key], r)
+			}
+		}
+
+		for _, document := range documents {
+			matches := make([]map[string]interface{}, 0)
+			for _, value := range keys(document) {
+				matches = append(matches, relatedByValue[fmt.Sprint(value)]...)
+			}
+
+			if relation.Many {
+				document[name] = matches
+			} else if len(matches) > 0 {
+				document[name] = matches[0]
+			} else {
+				document[name] = nil
+			}
+		}
+
+		if len(children) > 0 && len(related) > 0 {
+			if err = ma.populate(session, relation.Collection, related, children); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Returns the function that gives the ForeignField values of the documents
+// related to a document. For join relations the join documents of all the
+// documents are fetched with a single query.
+func (ma DataProvider) relationKeys(session *mgo.Session, relation Relation, documents []map[string]interface{}) (keys func(map[string]interface{}) []interface{}, err *utils.Error) {
+
+	if relation.Join == "" {
+		keys = func(document map[string]interface{}) []interface{} {
+			return relationValues(document[relation.LocalField])
+		}
+		return
+	}
+
+	values := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		values = append(values, relationValues(document[relation.LocalField])...)
+	}
+
+	var joins []map[string]interface{}
+	if len(values) > 0 {
+		findErr := ma.retry(session, 5, func() (err error) {
+			return session.DB(ma.Database).C(relation.Join).Find(bson.M{relation.JoinLocalField: bson.M{"$in": values}}).All(&joins)
+		})
+		if findErr != nil {
+			err = driverError(findErr, "Getting '"+relation.Join+"' joins failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason":     findErr.Error(),
+				"collection": relation.Join,
+				"relation":   relation.Name,
+			}).Error("Mongo Error: Getting join items failed.")
+			return
+		}
+	}
+
+	joined := make(map[string][]interface{})
+	for _, join := range joins {
+		for _, value := range relationValues(join[relation.JoinLocalField]) {
+			key := fmt.Sprint(value)
+			joined[key] = append(joined[key], relationValues(join[relation.JoinForeignField])...)
+		}
+	}
+
+	keys = func(document map[string]interface{}) (foreign []interface{}) {
+		for _, value := range relationValues(document[relation.LocalField]) {
+			foreign = append(foreign, joined[fmt.Sprint(value)]...)
+		}
+		return
+	}
+	return
+}
+
+// Returns the values of a reference field, which is either a single value or a list.
+func relationValues(field interface{}) (values []interface{}) {
+	switch v := field.(type) {
+	case nil:
+	case []interface{}:
+		values = v
+	default:
+		values = []interface{}{v}
+	}
+	return
+}
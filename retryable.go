@@ -0,0 +1,107 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// codes of the server errors that are caused by the state of the cluster
+// rather than the operation, so the operation may succeed when retried
+var retryableErrorCodes = map[int]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	112:   true, // WriteConflict, transient in transactions
+	189:   true, // PrimarySteppedDown
+	251:   true, // NoSuchTransaction, transient in transactions
+	9001:  true, // SocketException
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// Returns true if the error is transient, i.e. a network error, a timeout,
+// a primary change or a transient transaction error. Errors of the operation
+// itself, e.g. duplicate keys or invalid documents, are permanent and are
+// not retried since they can never succeed.
+// Example Usage:
+//
+//	provider.RetryableError = func(err error) bool {
+//	    return mongoutil.IsRetryable(err) || isQuotaError(err)
+//	}
+func IsRetryable(err error) bool {
+
+	if err == nil || err == mgo.ErrNotFound {
+		return false
+	}
+	if isConnectionError(err) || isTimeoutError(err) {
+		return true
+	}
+
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return retryableErrorCodes[e.Code]
+	case *mgo.LastError:
+		return retryableErrorCodes[e.Code]
+	}
+	return false
+}
+
+func (ma DataProvider) isRetryable(err error) bool {
+	if ma.RetryableError != nil {
+		return ma.RetryableError(err)
+	}
+	return IsRetryable(err)
+}
+
+// mgo doesn't support the retryable writes of the server, so a write whose
+// attempt fails during a failover may have been applied before it is retried
+// by retry. The writes that are not idempotent either check for their earlier
+// attempt or are retried with retryWrite.
+
+// codes of the retryable server errors that are returned before the write is
+// applied, e.g. by a secondary that was the primary when the write was sent
+var unappliedErrorCodes = map[int]bool{
+	91:    true, // ShutdownInProgress
+	112:   true, // WriteConflict
+	189:   true, // PrimarySteppedDown
+	251:   true, // NoSuchTransaction
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// Returns true if the server rejected the write, so it wasn't applied. The
+// writes failing with the connection errors and the timeouts may have been.
+func isUnapplied(err error) bool {
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return unappliedErrorCodes[e.Code]
+	case *mgo.LastError:
+		return unappliedErrorCodes[e.Code]
+	}
+	return false
+}
+
+// Retries the write only after the attempts that were surely not applied. Used
+// for the writes that cannot be applied twice and cannot tell whether their
+// earlier attempt was applied, e.g. $inc, $push and the versioned updates.
+func (ma DataProvider) retryWrite(session *mgo.Session, attempts int, function func() error) error {
+	isRetryable := ma.isRetryable
+	ma.RetryableError = func(err error) bool {
+		return isUnapplied(err) && isRetryable(err)
+	}
+	return ma.retry(session, attempts, function)
+}
+
+// Returns true if the document inserted by an earlier attempt exists, which
+// is the case when the retried insert fails with a duplicate id.
+func insertApplied(connection *mgo.Collection, id interface{}) bool {
+	count, countErr := connection.Find(bson.M{ID: id}).Count()
+	return countErr == nil && count > 0
+}
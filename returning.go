@@ -0,0 +1,109 @@
+package mongoutil
+
+import (
+	"bytes"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+)
+
+// documents that Update can return, like the returnDocument option of findOneAndUpdate
+type ReturnDocument string
+
+const (
+	ReturnBefore ReturnDocument = "before"
+	ReturnAfter  ReturnDocument = "after"
+)
+
+// Returns a copy of the provider whose Create returns the whole created document.
+// Example Usage:
+// user, err := provider.WithFullDocument().Create("users", data)
+func (ma DataProvider) WithFullDocument() *DataProvider {
+	ma.fullDocument = true
+	return &ma
+}
+
+// Returns a copy of the provider whose Update returns the document as it was
+// before or after the update.
+// Example Usage:
+// order, err := provider.WithReturnDocument(mongoutil.ReturnAfter).Update("orders", id, data)
+func (ma DataProvider) WithReturnDocument(document ReturnDocument) *DataProvider {
+	ma.returnDocument = document
+	return &ma
+}
+
+func (ma DataProvider) updateReturns() ReturnDocument {
+	if ma.returnDocument != "" {
+		return ma.returnDocument
+	}
+	return ma.ReturnDocument
+}
+
+// Returns the document that the update produced from the stored document.
+// The changes are the same ones the server applied, except for updatedAt in
+// date mode, which is set by the server to its own time.
+func (ma DataProvider) updatedDocument(collection string, before, changes map[string]interface{}) (after map[string]interface{}) {
+
+	after = copyDocument(before)
+	for k, v := range changes {
+		ma.applyField(after, k, v)
+	}
+	if _, updatedAtField := ma.TimestampFieldNames(collection); ma.TimestampMode == TimestampDate && updatedAtField != "" {
+		after[updatedAtField] = ma.now()
+	}
+	if ma.isVersioned(collection) {
+		after[Version] = int64(toFloat(before[Version])) + 1
+	}
+	return
+}
+
+// Returns the stored document as the response, with its fields decrypted.
+func (ma DataProvider) returnedDocument(collection string, stored map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	response = copyDocument(stored)
+	if err = ma.Encryption.decrypt(collection, response); err != nil {
+		response = nil
+		return
+	}
+	ma.hexIds(collection, response)
+	ma.stringFields(collection, response)
+	return
+}
+
+// Returns whether setting and unsetting the fields changes the stored document.
+// The values are compared as they are encoded, like the server compares them.
+func changesDocument(before map[string]interface{}, set, unset bson.M) bool {
+
+	for field, value := range set {
+		stored, found := storedField(before, field)
+		if !found {
+			return true
+		}
+		storedValue, storedErr := bson.Marshal(bson.M{"v": stored})
+		newValue, newErr := bson.Marshal(bson.M{"v": value})
+		if storedErr != nil || newErr != nil || !bytes.Equal(storedValue, newValue) {
+			return true
+		}
+	}
+	for field := range unset {
+		if _, found := storedField(before, field); found {
+			return true
+		}
+	}
+	return false
+}
+
+func storedField(document map[string]interface{}, path string) (value interface{}, found bool) {
+
+	value = document
+	for _, segment := range strings.Split(path, ".") {
+		embedded, isObject := asObject(value)
+		if !isObject {
+			return nil, false
+		}
+		if value, found = embedded[segment]; !found {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+)
+
+// Row-level security rule of a collection, merged into the filter of every
+// query on it. The requester sees the documents matching the filter of any of
+// their roles, and the filter returned by Func if it is set.
+// Example Usage:
+//
+//	provider.RowPolicies = map[string]mongoutil.RowPolicy{
+//	    "tickets": {
+//	        Filters: map[string]map[string]interface{}{
+//	            "admin":   nil, // all the documents
+//	            "support": {"status": map[string]interface{}{"$ne": "draft"}},
+//	        },
+//	        Func: func(rs requestscope.RequestScope, roles []string) (map[string]interface{}, *utils.Error) {
+//	            return map[string]interface{}{"region": rs.Get("region")}, nil
+//	        },
+//	    },
+//	}
+//	provider.WithRoles(rs).Query("tickets", parameters)
+type RowPolicy struct {
+	// filters per role. a nil filter gives the role access to all the documents.
+	// the requesters without any of the roles are rejected with 403
+	Filters map[string]map[string]interface{}
+	// computes a filter from the request, e.g. from the user's region
+	Func func(rs requestscope.RequestScope, roles []string) (filter map[string]interface{}, err *utils.Error)
+}
+
+// Returns a copy of the provider whose queries are restricted by the
+// RowPolicies for the requester in the requestscope. The roles are read from
+// RoleKey ("role" by default), as a string or []string.
+func (ma DataProvider) WithRoles(rs requestscope.RequestScope) *DataProvider {
+	ma.scope = &rs
+	return &ma
+}
+
+func (ma DataProvider) roleKey() string {
+	if ma.RoleKey == "" {
+		return "role"
+	}
+	return ma.RoleKey
+}
+
+// Returns the filter that the policy of the collection adds to the queries.
+func (ma DataProvider) rowFilter(collection string) (filter map[string]interface{}, hasFilter bool, err *utils.Error) {
+
+	policy, hasPolicy := ma.RowPolicies[collection]
+	if !hasPolicy {
+		return
+	}
+
+	// the policy fails closed for the providers that are not bound to a request
+	if ma.scope == nil {
+		err = &utils.Error{
+			Code:    http.StatusForbidden,
+			Message: "Queries on '" + collection + "' must be made for a request.",
+		}
+		return
+	}
+	roles := scopeRoles(*ma.scope, ma.roleKey())
+
+	var clauses []interface{}
+	if policy.Filters != nil {
+		unrestricted, matched := false, false
+		for _, role := range roles {
+			roleFilter, hasRole := policy.Filters[role]
+			if !hasRole {
+				continue
+			}
+			matched = true
+			if roleFilter == nil {
+				unrestricted = true
+				break
+			}
+			clauses = append(clauses, roleFilter)
+		}
+		if !matched {
+			err = &utils.Error{
+				Code:    http.StatusForbidden,
+				Message: "Requester is not allowed to query '" + collection + "'.",
+			}
+			return
+		}
+		if unrestricted {
+			clauses = nil
+		}
+	}
+
+	var filters []interface{}
+	if len(clauses) == 1 {
+		filters = append(filters, clauses[0])
+	} else if len(clauses) > 1 {
+		filters = append(filters, map[string]interface{}{"$or": clauses})
+	}
+
+	if policy.Func != nil {
+		var funcFilter map[string]interface{}
+		if funcFilter, err = policy.Func(*ma.scope, roles); err != nil {
+			return
+		}
+		if funcFilter != nil {
+			filters = append(filters, funcFilter)
+		}
+	}
+
+	switch len(filters) {
+	case 0:
+		return
+	case 1:
+		filter = filters[0].(map[string]interface{})
+	default:
+		filter = map[string]interface{}{"$and": filters}
+	}
+	hasFilter = true
+	return
+}
+
+// Returns the filter of the row policy combined with the filter of the type of
+// the provider, which restrict all the queries of the collection, including
+// the ones fetching the related documents.
+func (ma DataProvider) scopeFilter(collection string) (filter map[string]interface{}, hasFilter bool, err *utils.Error) {
+
+	if filter, hasFilter, err = ma.rowFilter(collection); err != nil {
+		return
+	}
+	if typeFilter, hasTypeFilter := ma.typeFilter(collection); hasTypeFilter && hasFilter {
+		filter = map[string]interface{}{"$and": []interface{}{filter, typeFilter}}
+	} else if hasTypeFilter {
+		filter, hasFilter = typeFilter, true
+	}
+	return
+}
+
+// Returns the filter restricted by the scope filter of the collection.
+func (ma DataProvider) scopedFilter(collection string, filter bson.M) (scoped bson.M, err *utils.Error) {
+
+	scope, hasScope, err := ma.scopeFilter(collection)
+	if err != nil || !hasScope {
+		return filter, err
+	}
+	if len(filter) == 0 {
+		return scope, nil
+	}
+	return bson.M{"$and": []interface{}{filter, scope}}, nil
+}
+
+// Returns the filter of the document with the id, restricted by the scope
+// filter of the collection so that the documents out of the scope are not
+// found. isScoped tells whether the scope filter restricts it.
+func (ma DataProvider) scopedIdFilter(collection, id string) (filter bson.M, isScoped bool, err *utils.Error) {
+
+	scope, isScoped, err := ma.scopeFilter(collection)
+	if err != nil || !isScoped {
+		return ma.idFilter(collection, id), false, err
+	}
+	return bson.M{"$and": []interface{}{ma.idFilter(collection, id), scope}}, true, nil
+}
@@ -0,0 +1,134 @@
+package mongoutil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// names of the destructive actions guarded by SafetyGuard
+const (
+	ActionPurge          = "purge"
+	ActionDeleteMany     = "deleteMany"
+	ActionDropCollection = "dropCollection"
+)
+
+// Requires destructive operations to be confirmed. An operation is confirmed
+// either by the static Token, or by a single use token returned from Propose
+// for the same action and target.
+// Example Usage:
+// provider.Safety = &mongoutil.SafetyGuard{ProposalTTL: time.Minute}
+// token, _ := provider.Safety.Propose(mongoutil.ActionPurge, "users")
+// provider.WithConfirmation(token).Purge("users", id)
+type SafetyGuard struct {
+	// accepted for every action if set, meant for automated tooling
+	Token string
+	// validity of the proposed tokens, defaults to 5 minutes
+	ProposalTTL time.Duration
+
+	mutex     sync.Mutex
+	proposals map[string]proposal
+	logger    Logger
+}
+
+type proposal struct {
+	action    string
+	target    string
+	expiresAt time.Time
+}
+
+// Proposes a destructive action on the target, which is a collection name.
+// Returns the token that confirms the action.
+func (g *SafetyGuard) Propose(action, target string) (token string, err *utils.Error) {
+
+	raw := make([]byte, 16)
+	if _, randErr := rand.Read(raw); randErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Generating confirmation token failed.",
+		}
+		return
+	}
+	token = hex.EncodeToString(raw)
+
+	ttl := g.ProposalTTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.proposals == nil {
+		g.proposals = make(map[string]proposal)
+	}
+
+	now := time.Now()
+	for t, p := range g.proposals {
+		if now.After(p.expiresAt) {
+			delete(g.proposals, t)
+		}
+	}
+	g.proposals[token] = proposal{action: action, target: target, expiresAt: now.Add(ttl)}
+
+	newLogEntry(g.logger).WithFields(LogFields{
+		"action": action,
+		"target": target,
+	}).Warning("Mongo Warning: Destructive action proposed.")
+	return
+}
+
+// Returns an error unless the token confirms the action on the target.
+// Proposed tokens are consumed by a successful check.
+func (g *SafetyGuard) check(action, target, token string) (err *utils.Error) {
+
+	if g == nil {
+		return
+	}
+
+	err = &utils.Error{
+		Code:    http.StatusPreconditionRequired,
+		Message: "'" + action + "' on '" + target + "' must be confirmed with a token.",
+	}
+	if token == "" {
+		return
+	}
+	if g.Token != "" && token == g.Token {
+		err = nil
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	p, isProposed := g.proposals[token]
+	if !isProposed || p.action != action || p.target != target || time.Now().After(p.expiresAt) {
+		return
+	}
+
+	delete(g.proposals, token)
+	err = nil
+	return
+}
+
+// Returns a copy of the provider that confirms its destructive
+// operations with the given token.
+func (ma DataProvider) WithConfirmation(token string) *DataProvider {
+	ma.confirmation = token
+	return &ma
+}
+
+func (ma DataProvider) confirm(action, target string) (err *utils.Error) {
+
+	err = ma.Safety.check(action, target, ma.confirmation)
+	if err != nil {
+		ma.logger().WithFields(LogFields{
+			"action": action,
+			"target": target,
+		}).Error("Mongo Error: Unconfirmed destructive action rejected.")
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"strings"
+)
+
+// operators that run server side code or evaluate arbitrary expressions
+var DefaultDeniedOperators = []string{
+	"$where",
+	"$expr",
+	"$function",
+	"$accumulator",
+}
+
+// Decides which query operators can be used in the filter and aggregate
+// parameters. If Allowed is set only the operators in it are accepted,
+// otherwise the ones in Denied are rejected. An empty policy rejects
+// DefaultDeniedOperators.
+type OperatorPolicy struct {
+	Allowed []string
+	Denied  []string
+}
+
+// Returns an error if the filter uses an operator that is not allowed.
+func (p *OperatorPolicy) checkFilter(filter interface{}) (err *utils.Error) {
+
+	if p == nil {
+		return
+	}
+
+	var invalid string
+	walkOperators(filter, func(operator string) bool {
+		if !p.isAllowed(operator) {
+			invalid = operator
+			return false
+		}
+		return true
+	})
+
+	if invalid != "" {
+		err = newError(ErrBadFilter, "Operator '"+invalid+"' is not allowed.")
+	}
+	return
+}
+
+// Same as checkFilter, but the stage names of the pipeline are not checked.
+func (p *OperatorPolicy) checkPipeline(pipeline interface{}) (err *utils.Error) {
+
+	if p == nil {
+		return
+	}
+
+	stages, _ := pipeline.([]interface{})
+	for _, stage := range stages {
+		stageMap, _ := stage.(map[string]interface{})
+		for _, body := range stageMap {
+			if err = p.checkFilter(body); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (p *OperatorPolicy) isAllowed(operator string) bool {
+
+	if len(p.Allowed) > 0 {
+		return containsString(p.Allowed, operator)
+	}
+
+	denied := p.Denied
+	if len(denied) == 0 {
+		denied = DefaultDeniedOperators
+	}
+	return !containsString(denied, operator)
+}
+
+// Calls visit with every key starting with '$' in the value, until visit returns false.
+func walkOperators(value interface{}, visit func(operator string) bool) bool {
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if strings.HasPrefix(k, "$") && !visit(k) {
+				return false
+			}
+			if !walkOperators(child, visit) {
+				return false
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if !walkOperators(child, visit) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Rejects the requests whose where, or, nor, flattened filter or aggregate
+// parameters use operators that are not allowed by the policy given as
+// extras (*OperatorPolicy).
+// DefaultDeniedOperators are rejected if no policy is given.
+// Example Usage:
+// core.Interceptors.Add(interceptors.AnyPath, methods.Get, interceptors.BEFORE_EXEC, mongoutil.SanitizeQuery, nil)
+func SanitizeQuery(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	policy, _ := extras.(*OperatorPolicy)
+	if policy == nil {
+		policy = &OperatorPolicy{}
+	}
+
+	where, _, err := extractJsonParameter(req.Parameters, "where")
+	if err != nil {
+		return
+	}
+	if err = policy.checkFilter(where); err != nil {
+		return
+	}
+
+	// the or and nor parameters and the flattened filters are merged into the filter too
+	logical, _, err := logicalFilter(req.Parameters)
+	if err != nil {
+		return
+	}
+	if err = policy.checkFilter(logical); err != nil {
+		return
+	}
+	friendly, _, err := friendlyFilter(req.Parameters)
+	if err != nil {
+		return
+	}
+	if err = policy.checkFilter(friendly); err != nil {
+		return
+	}
+
+	pipeline, _, err := extractJsonParameter(req.Parameters, "aggregate")
+	if err != nil {
+		return
+	}
+	err = policy.checkPipeline(pipeline)
+	return
+}
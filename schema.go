@@ -0,0 +1,165 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"github.com/xeipuuv/gojsonschema"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Registers the JSON Schema that the documents of the collection are validated
+// against on Create and Update. If pushToServer is set, the schema is also set
+// as the $jsonSchema validator of the collection, which requires the provider
+// to be connected. Note that mongo supports only
+// a subset of JSON Schema and uses 'bsonType' in place of some of the types.
+// Example Usage:
+//
+//	err := provider.RegisterSchema("users", map[string]interface{}{
+//	    "type":     "object",
+//	    "required": []string{"name"},
+//	    "properties": map[string]interface{}{
+//	        "name": map[string]interface{}{"type": "string"},
+//	    },
+//	}, false)
+func (ma *DataProvider) RegisterSchema(collection string, schema map[string]interface{}, pushToServer bool) (err *utils.Error) {
+
+	compiled, schemaErr := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+	if schemaErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Schema of '" + collection + "' is not valid. Reason: " + schemaErr.Error(),
+		}
+		return
+	}
+
+	if pushToServer {
+		if err = ma.pushValidator(collection, bson.M{"$jsonSchema": schema}); err != nil {
+			return
+		}
+
+		// the pushed validator is part of the drift check from now on
+		if ma.Validators == nil {
+			ma.Validators = make(map[string]bson.M)
+		}
+		ma.Validators[collection] = bson.M{"$jsonSchema": schema}
+	}
+
+	if ma.state == nil {
+		ma.state = &providerState{session: ma.session}
+	}
+	ma.state.mutex.Lock()
+	if ma.state.schemas == nil {
+		ma.state.schemas = make(map[string]*gojsonschema.Schema)
+	}
+	ma.state.schemas[collection] = compiled
+	ma.state.mutex.Unlock()
+	return
+}
+
+// Sets the validator of the collection, creating the collection if it doesn't exist.
+func (ma DataProvider) pushValidator(collection string, validator bson.M) (err *utils.Error) {
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(5 * time.Second)
+	db := sessionCopy.DB(ma.Database)
+
+	runErr := db.Run(bson.D{{Name: "collMod", Value: collection}, {Name: "validator", Value: validator}}, nil)
+	if runErr != nil && isNamespaceNotFound(runErr) {
+		runErr = db.Run(bson.D{{Name: "create", Value: collection}, {Name: "validator", Value: validator}}, nil)
+	}
+	if runErr != nil {
+		err = driverError(runErr, "Setting validator of '"+collection+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     runErr.Error(),
+			"collection": collection,
+		}).Error("Mongo Error: Setting validator failed.")
+	}
+	return
+}
+
+// Validates the document against the schema registered for the collection.
+// Returns 400 listing the errors of all the fields.
+func (ma DataProvider) validateSchema(collection string, document map[string]interface{}) (err *utils.Error) {
+
+	if ma.state == nil {
+		return
+	}
+
+	ma.state.mutex.RLock()
+	schema := ma.state.schemas[collection]
+	var typeSchema *gojsonschema.Schema
+	if typeName := ma.documentType(collection, document); typeName != "" {
+		typeSchema = ma.state.schemas[typeKey(collection, typeName)]
+	}
+	ma.state.mutex.RUnlock()
+
+	if err = validateDocument(schema, document); err != nil {
+		return
+	}
+	return validateDocument(typeSchema, document)
+}
+
+func validateDocument(schema *gojsonschema.Schema, document map[string]interface{}) (err *utils.Error) {
+
+	if schema == nil {
+		return
+	}
+
+	result, validateErr := schema.Validate(gojsonschema.NewGoLoader(document))
+	if validateErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Validating input failed. Reason: " + validateErr.Error(),
+		}
+		return
+	}
+	if result.Valid() {
+		return
+	}
+
+	fieldErrors := make([]string, 0, len(result.Errors()))
+	for _, resultErr := range result.Errors() {
+		fieldErrors = append(fieldErrors, resultErr.Field()+": "+resultErr.Description())
+	}
+	err = &utils.Error{
+		Code:    http.StatusBadRequest,
+		Message: "Input is not valid. " + strings.Join(fieldErrors, "; "),
+	}
+	return
+}
+
+func (ma DataProvider) hasSchema(collection string) bool {
+
+	if ma.state == nil {
+		return false
+	}
+	ma.state.mutex.RLock()
+	hasSchema := ma.state.schemas[collection] != nil
+	ma.state.mutex.RUnlock()
+	return hasSchema || ma.hasTypeSchemas(collection)
+}
+
+// Validates the document that the update will produce.
+func (ma DataProvider) validateUpdate(collection string, stored, data map[string]interface{}) (err *utils.Error) {
+
+	if ma.state == nil || ma.state.schemas == nil {
+		return
+	}
+
+	merged := make(map[string]interface{}, len(stored)+len(data))
+	for k, v := range stored {
+		merged[k] = v
+	}
+	if err = ma.Encryption.decrypt(collection, merged); err != nil {
+		return
+	}
+	for k, v := range data {
+		ma.applyField(merged, k, v)
+	}
+	return ma.validateSchema(collection, merged)
+}
@@ -0,0 +1,113 @@
+package mongoutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"time"
+)
+
+// Inserts the documents whose ids don't exist in the collection yet, so it can
+// be run at every startup. Every document must have an _id. Soft deleted
+// documents exist, so they are not inserted again. The response contains the
+// number of "inserted" and "skipped" documents.
+// Example Usage:
+// provider.Seed("countries", []map[string]interface{}{{"_id": "tr", "name": "Turkey"}})
+func (ma DataProvider) Seed(collection string, documents []map[string]interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("seed", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	// the ids are looked up as they are stored, since their types must match
+	ids := make([]interface{}, 0, len(documents))
+	for i, document := range documents {
+		id, hasId := document[ID]
+		if !hasId || id == "" {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Seed document at index %d must have an '%s'.", i, ID),
+			}
+			return
+		}
+		ids = append(ids, ma.storedId(collection, id))
+	}
+
+	sessionCopy := ma.copySession(collection, true, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+
+	var existing []map[string]interface{}
+	findErr := ma.retry(sessionCopy, ma.attempts(collection), func() error {
+		return sessionCopy.DB(ma.Database).C(collection).Find(bson.M{ID: bson.M{"$in": ids}}).Select(bson.M{ID: 1}).All(&existing)
+	})
+	if findErr != nil {
+		err = driverError(findErr, "Getting seeded ids of '"+collection+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     findErr.Error(),
+			"collection": collection,
+		}).Error("Mongo Error: Getting seeded ids failed.")
+		return
+	}
+	exists := make(map[string]bool, len(existing))
+	for _, document := range existing {
+		exists[seedKey(document[ID])] = true
+	}
+
+	inserted := 0
+	for i, document := range documents {
+		if exists[seedKey(ids[i])] {
+			continue
+		}
+
+		// Create adds the generated fields to the document so the caller's is not modified
+		copied := make(map[string]interface{}, len(document))
+		for k, v := range document {
+			copied[k] = v
+		}
+		if _, err = ma.Create(collection, copied); err != nil {
+			// inserted by another instance seeding at the same time
+			if KindOf(err) == ErrDuplicate {
+				err = nil
+				continue
+			}
+			return
+		}
+		inserted++
+	}
+
+	response = map[string]interface{}{
+		"inserted": inserted,
+		"skipped":  len(documents) - inserted,
+	}
+	return
+}
+
+// the ids read back from the server may have other integer types than the seeded ones
+func seedKey(id interface{}) string {
+	switch id.(type) {
+	case int, int32, int64, float64:
+		return "number:" + fmt.Sprint(toFloat(id))
+	}
+	return fmt.Sprintf("%T:%v", id, id)
+}
+
+// Same as Seed but reads the documents from a json array, e.g. a file
+// embedded with go:embed.
+func (ma DataProvider) SeedJSON(collection string, data []byte) (response map[string]interface{}, err *utils.Error) {
+
+	var documents []map[string]interface{}
+	if parseErr := json.Unmarshal(data, &documents); parseErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Parsing seed documents failed. Reason: " + parseErr.Error(),
+		}
+		return
+	}
+	return ma.Seed(collection, documents)
+}
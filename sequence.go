@@ -0,0 +1,66 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+// collection that keeps the current values of the sequences
+var SequenceCollection = "counters"
+
+// Increases the sequence atomically and returns its new value. Sequences
+// start from 1 and are created on their first use. Values are never reused,
+// but a value taken by a failed operation leaves a gap.
+// Example Usage:
+// number, err := provider.NextSequence("invoices")
+func (ma DataProvider) NextSequence(name string) (value int64, err *utils.Error) {
+
+	op, err := ma.begin("nextSequence", SequenceCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(SequenceCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(SequenceCollection)
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"value": int64(1)}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	// concurrent first uses may both try to insert the counter, and the one that
+	// loses fails with a duplicate key error. IsRetryable doesn't retry those,
+	// so it is applied again here, which increases the counter inserted by the other
+	sequenceErr := ma.retry(sessionCopy, ma.attempts(SequenceCollection), func() (err error) {
+		_, err = connection.FindId(name).Apply(change, &counter)
+		if mgo.IsDup(err) {
+			_, err = connection.FindId(name).Apply(change, &counter)
+		}
+		return
+	})
+	if sequenceErr != nil {
+		err = driverError(sequenceErr, "Increasing sequence '"+name+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":   sequenceErr.Error(),
+			"sequence": name,
+		}).Error("Mongo Error: Increasing sequence failed.")
+		return
+	}
+
+	value = counter.Value
+	return
+}
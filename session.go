@@ -0,0 +1,58 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2"
+	"io"
+	"net"
+	"strings"
+)
+
+// messages of the errors that mgo returns when the connection to the
+// server is lost or the primary is no longer available
+var connectionErrorMessages = []string{
+	"Closed explicitly",
+	"no reachable servers",
+	"not master",
+	"node is recovering",
+	"connection reset by peer",
+	"broken pipe",
+}
+
+// Returns true if the error is caused by the connection rather than the
+// operation itself, meaning that the operation may succeed on a fresh socket.
+func isConnectionError(err error) bool {
+
+	if err == nil {
+		return false
+	}
+
+	if err == io.EOF {
+		return true
+	}
+
+	if _, isNetErr := err.(net.Error); isNetErr {
+		return true
+	}
+
+	message := err.Error()
+	for _, m := range connectionErrorMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Releases the sockets of both the given session and the master session, so
+// the following operations re-discover the cluster and pick the new primary.
+func (ma DataProvider) refreshSession(session *mgo.Session) {
+
+	ma.eventLogger(LogEventSessionRefresh, LogWarning).Log("Mongo Warning: Connection error detected. Refreshing session.")
+
+	if session != nil {
+		session.Refresh()
+	}
+	if ma.session != nil && ma.session != session {
+		ma.session.Refresh()
+	}
+}
@@ -0,0 +1,129 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Returns the fields of the shard key, in mgo's index key notation.
+func shardKeyFields(key []string) (fields []string) {
+	for _, field := range key {
+		field = strings.TrimPrefix(field, "$hashed:")
+		fields = append(fields, strings.TrimPrefix(field, "+"))
+	}
+	return
+}
+
+// Converts the shard key in mgo's index key notation to the document of shardCollection.
+func shardKeyDocument(key []string) (document bson.D) {
+	for _, field := range key {
+		if strings.HasPrefix(field, "$hashed:") {
+			document = append(document, bson.DocElem{Name: strings.TrimPrefix(field, "$hashed:"), Value: "hashed"})
+			continue
+		}
+		document = append(document, bson.DocElem{Name: strings.TrimPrefix(field, "+"), Value: 1})
+	}
+	return
+}
+
+// Returns 400 if the created document lacks a field of its shard key,
+// since mongos cannot route the write to a shard without it.
+func (ma DataProvider) checkShardKey(collection string, document map[string]interface{}) (err *utils.Error) {
+	for _, field := range shardKeyFields(ma.ShardKeys[collection]) {
+		if _, hasField := document[field]; !hasField {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Documents of '" + collection + "' must contain the shard key field '" + field + "'.",
+			}
+			return
+		}
+	}
+	return
+}
+
+// Removes the shard key from the update and returns it as a filter. The update
+// is routed to the shard by the filter and the shard key is left unchanged.
+func (ma DataProvider) extractShardKey(collection string, data map[string]interface{}) (filter bson.M, err *utils.Error) {
+
+	filter = bson.M{}
+	for _, field := range shardKeyFields(ma.ShardKeys[collection]) {
+		// the id is already in the filter of the update
+		if field == ID {
+			continue
+		}
+		value, hasField := data[field]
+		if !hasField {
+			err = &utils.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Updates of '" + collection + "' must contain the shard key field '" + field + "'.",
+			}
+			return
+		}
+		filter[field] = value
+		delete(data, field)
+	}
+	return
+}
+
+// Enables sharding for the database and shards the collections by their keys
+// in ShardKeys. Collections that are already sharded are left as they are.
+// Called by Connect. The provider must be connected to a mongos.
+func (ma DataProvider) ShardCollections() (err *utils.Error) {
+
+	op, err := ma.begin("shardCollections", "")
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	sessionCopy := ma.session.Copy()
+	defer sessionCopy.Close()
+	sessionCopy.SetSyncTimeout(1 * time.Second)
+	sessionCopy.SetSocketTimeout(30 * time.Second)
+	admin := sessionCopy.DB("admin")
+
+	enableErr := admin.Run(bson.D{{Name: "enableSharding", Value: ma.Database}}, nil)
+	if enableErr != nil && !isAlreadySharded(enableErr) {
+		err = driverError(enableErr, "Enabling sharding for '"+ma.Database+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":   enableErr.Error(),
+			"database": ma.Database,
+		}).Error("Mongo Error: Enabling sharding failed.")
+		return
+	}
+
+	for collection, key := range ma.ShardKeys {
+		command := bson.D{
+			{Name: "shardCollection", Value: ma.Database + "." + collection},
+			{Name: "key", Value: shardKeyDocument(key)},
+		}
+		shardErr := admin.Run(command, nil)
+		if shardErr != nil && !isAlreadySharded(shardErr) {
+			err = driverError(shardErr, "Sharding '"+collection+"' failed.")
+
+			ma.logger().WithFields(LogFields{
+				"reason":     shardErr.Error(),
+				"collection": collection,
+			}).Error("Mongo Error: Sharding collection failed.")
+			return
+		}
+	}
+	return
+}
+
+// Returns true if the error is caused by sharding what is already sharded.
+func isAlreadySharded(err error) bool {
+
+	// 20: already sharded, 23: already enabled
+	queryErr, isQueryErr := err.(*mgo.QueryError)
+	if isQueryErr && (queryErr.Code == 20 || queryErr.Code == 23) {
+		return true
+	}
+	return strings.Contains(err.Error(), "already")
+}
@@ -0,0 +1,163 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"time"
+)
+
+func (ma DataProvider) isSoftDelete(collection string) bool {
+	return ma.SoftDelete[collection]
+}
+
+// Returns the filter matching the document with the id, excluding
+// the soft deleted document for the collections in SoftDelete.
+func (ma DataProvider) idFilter(collection, id string) bson.M {
+	if ma.isSoftDelete(collection) {
+		return bson.M{ID: ma.storedId(collection, id), DeletedAt: bson.M{"$exists": false}}
+	}
+	return bson.M{ID: ma.storedId(collection, id)}
+}
+
+// Adds the exclusion of soft deleted documents to the where filter.
+func (ma DataProvider) excludeDeleted(collection string, where interface{}) interface{} {
+
+	if !ma.isSoftDelete(collection) {
+		return where
+	}
+
+	notDeleted := bson.M{DeletedAt: bson.M{"$exists": false}}
+	if where == nil {
+		return notDeleted
+	}
+	return bson.M{"$and": []interface{}{where, notDeleted}}
+}
+
+// Adds a $match stage excluding soft deleted documents to the beginning of the pipeline.
+func (ma DataProvider) excludeDeletedStages(collection string, pipeline interface{}) interface{} {
+
+	stages, isList := pipeline.([]interface{})
+	if !ma.isSoftDelete(collection) || !isList {
+		return pipeline
+	}
+
+	match := bson.M{"$match": bson.M{DeletedAt: bson.M{"$exists": false}}}
+	return append([]interface{}{match}, stages...)
+}
+
+// Marks the document as deleted by setting its deletedAt field.
+func (ma DataProvider) softDelete(session *mgo.Session, collection string, filter bson.M) (deletedAt interface{}, err error) {
+
+	deletedAt = ma.now()
+	err = ma.retry(session, ma.attempts(collection), func() (err error) {
+		return session.DB(ma.Database).C(collection).Update(
+			filter,
+			bson.M{"$set": bson.M{DeletedAt: deletedAt}},
+		)
+	})
+	return
+}
+
+// Recovers a soft deleted document by removing its deletedAt field.
+func (ma DataProvider) Restore(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("restore", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
+
+	// the documents out of the scope of the requester are not found
+	filter, err := ma.scopedFilter(collection, bson.M{ID: ma.storedId(collection, id), DeletedAt: bson.M{"$exists": true}})
+	if err != nil {
+		return
+	}
+
+	_, updatedAtField := ma.TimestampFieldNames(collection)
+	updatedAt := ma.now()
+	change := bson.M{"$unset": bson.M{DeletedAt: ""}}
+	if updatedAtField != "" {
+		change["$set"] = bson.M{updatedAtField: updatedAt}
+	}
+
+	restoreErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+		return connection.Update(filter, change)
+	})
+	if restoreErr != nil {
+		if restoreErr == mgo.ErrNotFound {
+			err = newError(ErrNotFound, "Deleted '"+collection+"' with id '"+id+"' not found.")
+		} else {
+			err = driverError(restoreErr, "Restoring '"+collection+"' with id '"+id+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     restoreErr.Error(),
+			"collection": collection,
+			"id":         id,
+		}).Error("Mongo Error: Restoring item failed.")
+		return
+	}
+
+	ma.uncache(collection, id)
+	ma.InvalidateQueries(collection)
+	response = make(map[string]interface{})
+	setField(response, updatedAtField, updatedAt)
+	return
+}
+
+// Removes the document permanently, whether it is soft deleted or not.
+// Must be confirmed if Safety is set.
+func (ma DataProvider) Purge(collection string, id string) (response map[string]interface{}, err *utils.Error) {
+
+	op, err := ma.begin("purge", collection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	if err = ma.confirm(ActionPurge, collection); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(collection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(collection)
+
+	removeErr := ma.retry(sessionCopy, ma.attempts(collection), func() (err error) {
+		return connection.RemoveId(ma.storedId(collection, id))
+	})
+	if removeErr != nil {
+		if removeErr == mgo.ErrNotFound {
+			err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		} else {
+			err = driverError(removeErr, "Purging '"+collection+"' with id '"+id+"' failed.")
+		}
+
+		ma.logger().WithFields(LogFields{
+			"reason":     removeErr.Error(),
+			"collection": collection,
+			"id":         id,
+		}).Error("Mongo Error: Purging item failed.")
+		return
+	}
+
+	ma.uncache(collection, id)
+	ma.InvalidateQueries(collection)
+	op.countWritten(nil)
+	return
+}
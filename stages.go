@@ -0,0 +1,73 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+)
+
+// stages accepted in the aggregate parameter if AllowedStages is not set.
+// stages that write to or read from other collections are left out.
+var DefaultAllowedStages = []string{
+	"$match",
+	"$group",
+	"$sort",
+	"$limit",
+	"$skip",
+	"$project",
+	"$addFields",
+	"$set",
+	"$unset",
+	"$unwind",
+	"$count",
+	"$facet",
+	"$bucket",
+	"$bucketAuto",
+	"$sortByCount",
+	"$replaceRoot",
+	"$replaceWith",
+	"$sample",
+}
+
+// Returns an error if the pipeline contains a stage that is not allowed.
+// The sub-pipelines of $facet are checked too.
+func (ma DataProvider) checkStages(pipeline interface{}) (err *utils.Error) {
+
+	if pipeline == nil {
+		return
+	}
+
+	allowed := ma.AllowedStages
+	if allowed == nil {
+		allowed = DefaultAllowedStages
+	}
+
+	stages, isList := pipeline.([]interface{})
+	if !isList {
+		err = newError(ErrBadFilter, "Aggregation pipeline must be a list of stages.")
+		return
+	}
+
+	for _, stage := range stages {
+		stageMap, isMap := stage.(map[string]interface{})
+		if !isMap || len(stageMap) != 1 {
+			err = newError(ErrBadFilter, "Each stage of the pipeline must have a single key.")
+			return
+		}
+
+		for name, body := range stageMap {
+			if !containsString(allowed, name) {
+				err = newError(ErrBadFilter, "Aggregation stage '"+name+"' is not allowed.")
+				return
+			}
+
+			if name == "$facet" {
+				facets, _ := body.(map[string]interface{})
+				for _, facet := range facets {
+					if err = ma.checkStages(facet); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2"
+	"sync/atomic"
+	"time"
+)
+
+// counters of the operations since the provider connected, kept in providerState
+type operationCounters struct {
+	inFlight   int64
+	operations int64
+	errors     int64
+	retries    int64
+}
+
+// Health of the provider, returned by Stats.
+type Stats struct {
+	// operations running at the moment
+	InFlight int64 `json:"inFlight"`
+	// operations finished, failed and attempts retried since the provider connected
+	Operations int64     `json:"operations"`
+	Errors     int64     `json:"errors"`
+	Retries    int64     `json:"retries"`
+	Since      time.Time `json:"since"`
+
+	// sockets of the connection pool. mgo keeps these per process,
+	// so they include the sockets of the other providers of the process
+	SocketsAlive int `json:"socketsAlive"`
+	SocketsInUse int `json:"socketsInUse"`
+}
+
+// Returns the in-flight operations, the pool usage and the operation, error
+// and retry counts since the provider connected, for the health endpoints.
+// Example Usage:
+//
+//	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+//	    json.NewEncoder(w).Encode(provider.Stats())
+//	})
+func (ma DataProvider) Stats() (stats Stats) {
+
+	if ma.state == nil {
+		return
+	}
+
+	counters := &ma.state.counters
+	stats.InFlight = atomic.LoadInt64(&counters.inFlight)
+	stats.Operations = atomic.LoadInt64(&counters.operations)
+	stats.Errors = atomic.LoadInt64(&counters.errors)
+	stats.Retries = atomic.LoadInt64(&counters.retries)
+
+	ma.state.mutex.RLock()
+	stats.Since = ma.state.connectedAt
+	ma.state.mutex.RUnlock()
+
+	pool := mgo.GetStats()
+	stats.SocketsAlive = pool.SocketsAlive
+	stats.SocketsInUse = pool.SocketsInUse
+	return
+}
+
+func (ma DataProvider) countStarted() {
+	if ma.state != nil {
+		atomic.AddInt64(&ma.state.counters.inFlight, 1)
+	}
+}
+
+func (ma DataProvider) countFinished(failed bool) {
+
+	if ma.state == nil {
+		return
+	}
+	atomic.AddInt64(&ma.state.counters.inFlight, -1)
+	atomic.AddInt64(&ma.state.counters.operations, 1)
+	if failed {
+		atomic.AddInt64(&ma.state.counters.errors, 1)
+	}
+}
+
+func (ma DataProvider) countRetry() {
+	if ma.state != nil {
+		atomic.AddInt64(&ma.state.counters.retries, 1)
+	}
+}
@@ -0,0 +1,114 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pushes operation metrics to a StatsD agent over UDP. Tags are written in the
+// Datadog format so the metrics can be sliced by operation, collection and status.
+// Example Usage:
+// provider.StatsD = &mongoutil.StatsDEmitter{Address: "127.0.0.1:8125", Prefix: "mongo", SampleRate: 0.5}
+type StatsDEmitter struct {
+	Address string
+	Prefix  string
+	// tags added to every metric, e.g. "env:prod"
+	Tags []string
+	// ratio of the operations that are reported, between 0 and 1. defaults to 1
+	SampleRate float64
+
+	conn   net.Conn
+	logger Logger
+}
+
+func (s *StatsDEmitter) Init() (err *utils.Error) {
+
+	if s.Address == "" {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "StatsD 'address' must be specified.",
+		}
+		return
+	}
+
+	if s.SampleRate <= 0 || s.SampleRate > 1 {
+		s.SampleRate = 1
+	}
+
+	var dialErr error
+	s.conn, dialErr = net.Dial("udp", s.Address)
+	if dialErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Connecting to StatsD agent failed.",
+		}
+
+		newLogEntry(s.logger).WithFields(LogFields{
+			"reason":  dialErr.Error(),
+			"address": s.Address,
+		}).Error("StatsD Error: Connection failed.")
+	}
+	return
+}
+
+func (s *StatsDEmitter) Close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// Reports a counter and a timer for the finished operation, sampled by SampleRate.
+// The shape is the hash of the query fingerprint if it is declared in ShapeHints,
+// "other" for the other queries and empty for other operations.
+func (s *StatsDEmitter) ObserveOperation(operation, collection, shape string, duration time.Duration, failed bool) {
+
+	if s == nil || s.conn == nil {
+		return
+	}
+	if s.SampleRate < 1 && rand.Float64() >= s.SampleRate {
+		return
+	}
+
+	status := "success"
+	if failed {
+		status = "error"
+	}
+
+	tags := append([]string{
+		"operation:" + operation,
+		"collection:" + collection,
+		"status:" + status,
+	}, s.Tags...)
+	if shape != "" {
+		tags = append(tags, "shape:"+shape)
+	}
+
+	s.send("operations", "1", "c", tags)
+	s.send("operation.duration", strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64), "ms", tags)
+}
+
+func (s *StatsDEmitter) send(name, value, metricType string, tags []string) {
+
+	line := name + ":" + value + "|" + metricType
+	if s.Prefix != "" {
+		line = s.Prefix + "." + line
+	}
+	if s.SampleRate < 1 {
+		line += "|@" + strconv.FormatFloat(s.SampleRate, 'f', -1, 64)
+	}
+	if len(tags) > 0 {
+		line += "|#" + strings.Join(tags, ",")
+	}
+
+	// udp writes do not block on the agent, losing a metric is acceptable
+	if _, writeErr := s.conn.Write([]byte(line)); writeErr != nil {
+		newLogEntry(s.logger).WithFields(LogFields{
+			"reason": writeErr.Error(),
+		}).Debug("StatsD Error: Sending metric failed.")
+	}
+}
@@ -0,0 +1,153 @@
+package mongoutil
+
+import (
+	"sort"
+)
+
+// types of the change events
+const (
+	ChangeInsert = "insert"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// size of the buffer of each subscription. events are dropped
+// if the subscriber falls behind by more than this
+const subscriptionBufferSize = 100
+
+type ChangeEvent struct {
+	Type          string                 `json:"type"`
+	Collection    string                 `json:"collection"`
+	ID            interface{}            `json:"id"`
+	Before        map[string]interface{} `json:"before,omitempty"`
+	After         map[string]interface{} `json:"after,omitempty"`
+	ChangedFields []string               `json:"changedFields,omitempty"`
+}
+
+type Subscription struct {
+	Events <-chan ChangeEvent
+
+	collection string
+	fields     map[string]bool
+	events     chan ChangeEvent
+	state      *providerState
+}
+
+// Subscribes to the changes made through this provider on the collection.
+// If fields are given, update events are delivered only when one of these
+// fields changed. The changes made by other processes are not observed.
+// Example Usage:
+// sub := provider.Subscribe("orders", "status")
+// defer sub.Unsubscribe()
+// for event := range sub.Events { ... }
+func (ma DataProvider) Subscribe(collection string, fields ...string) (subscription *Subscription) {
+
+	events := make(chan ChangeEvent, subscriptionBufferSize)
+	subscription = &Subscription{
+		Events:     events,
+		collection: collection,
+		events:     events,
+		state:      ma.state,
+	}
+
+	if len(fields) > 0 {
+		subscription.fields = make(map[string]bool, len(fields))
+		for _, field := range fields {
+			subscription.fields[field] = true
+		}
+	}
+
+	if ma.state == nil {
+		close(events)
+		return
+	}
+
+	ma.state.mutex.Lock()
+	if ma.state.subscriptions == nil {
+		ma.state.subscriptions = make(map[string][]*Subscription)
+	}
+	ma.state.subscriptions[collection] = append(ma.state.subscriptions[collection], subscription)
+	ma.state.mutex.Unlock()
+	return
+}
+
+// Stops the delivery of the events and closes the Events channel.
+func (s *Subscription) Unsubscribe() {
+
+	if s.state == nil {
+		return
+	}
+
+	s.state.mutex.Lock()
+	defer s.state.mutex.Unlock()
+
+	subscriptions := s.state.subscriptions[s.collection]
+	for i, sub := range subscriptions {
+		if sub == s {
+			s.state.subscriptions[s.collection] = append(subscriptions[:i], subscriptions[i+1:]...)
+			close(s.events)
+			return
+		}
+	}
+}
+
+func (ma DataProvider) hasSubscribers(collection string) bool {
+
+	if ma.state == nil {
+		return false
+	}
+
+	ma.state.mutex.RLock()
+	defer ma.state.mutex.RUnlock()
+	return len(ma.state.subscriptions[collection]) > 0
+}
+
+// Delivers the event to the subscribers of the collection without blocking the write.
+func (ma DataProvider) publishChange(event ChangeEvent) {
+
+	if !ma.hasSubscribers(event.Collection) {
+		return
+	}
+
+	if event.Type == ChangeUpdate {
+		_, updatedAtField := ma.TimestampFieldNames(event.Collection)
+		for field := range diffFields(event.Before, event.After) {
+			if field != updatedAtField {
+				event.ChangedFields = append(event.ChangedFields, field)
+			}
+		}
+		sort.Strings(event.ChangedFields)
+	}
+
+	ma.state.mutex.RLock()
+	defer ma.state.mutex.RUnlock()
+
+	for _, sub := range ma.state.subscriptions[event.Collection] {
+		if !sub.isInterested(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			ma.logger().WithFields(LogFields{
+				"collection": event.Collection,
+				"id":         event.ID,
+			}).Warning("Mongo Warning: Subscriber is not keeping up. Dropping change event.")
+		}
+	}
+}
+
+func (s *Subscription) isInterested(event ChangeEvent) bool {
+
+	if s.fields == nil || event.Type != ChangeUpdate {
+		return true
+	}
+
+	for _, field := range event.ChangedFields {
+		if s.fields[field] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package mongoutil
+
+// Names of the timestamp fields of a collection. Empty names are replaced
+// by the defaults createdAt and updatedAt, and "-" disables the field.
+// Example Usage:
+// provider.TimestampFields = mongoutil.TimestampFields{CreatedAt: "created_at", UpdatedAt: "updated_at"}
+// provider.CollectionTimestampFields = map[string]mongoutil.TimestampFields{"events": {UpdatedAt: "-"}}
+type TimestampFields struct {
+	CreatedAt string
+	UpdatedAt string
+}
+
+// Returns the names of the timestamp fields of the collection.
+// Disabled fields are returned as empty strings.
+// Example Usage:
+// createdAtField, _ := provider.TimestampFieldNames("users")
+func (ma DataProvider) TimestampFieldNames(collection string) (createdAt, updatedAt string) {
+
+	fields := ma.TimestampFields
+	if override, hasOverride := ma.CollectionTimestampFields[collection]; hasOverride {
+		if override.CreatedAt != "" {
+			fields.CreatedAt = override.CreatedAt
+		}
+		if override.UpdatedAt != "" {
+			fields.UpdatedAt = override.UpdatedAt
+		}
+	}
+	return fieldName(fields.CreatedAt, CreatedAt), fieldName(fields.UpdatedAt, UpdatedAt)
+}
+
+func fieldName(name, defaultName string) string {
+	switch name {
+	case "":
+		return defaultName
+	case "-":
+		return ""
+	}
+	return name
+}
+
+// Sets the field unless it is disabled.
+func setField(document map[string]interface{}, field string, value interface{}) {
+	if field != "" {
+		document[field] = value
+	}
+}
@@ -0,0 +1,71 @@
+package mongoutil
+
+import (
+	"fmt"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"strings"
+)
+
+// key of the requestscope that holds the tenant if TenantKey is not set
+const DefaultTenantKey = "tenantId"
+
+// characters that mongo doesn't allow in database names
+const invalidDatabaseChars = "/\\. \"$*<>:|?"
+
+func (ma DataProvider) tenantKey() string {
+	if ma.TenantKey == "" {
+		return DefaultTenantKey
+	}
+	return ma.TenantKey
+}
+
+// Returns a copy of the provider that works on the database of the tenant.
+// The database is resolved by TenantDatabase, or named <Database>_<tenant>
+// if it is not set.
+// Example Usage:
+// tenantProvider, err := provider.WithTenant("acme")
+func (ma DataProvider) WithTenant(tenant string) (provider *DataProvider, err *utils.Error) {
+
+	if tenant == "" {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Tenant cannot be empty.",
+		}
+		return
+	}
+
+	database := ma.Database + "_" + tenant
+	if ma.TenantDatabase != nil {
+		database = ma.TenantDatabase(tenant)
+	}
+
+	if database == "" || strings.ContainsAny(database, invalidDatabaseChars) {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Tenant '" + tenant + "' does not resolve to a valid database name.",
+		}
+		return
+	}
+
+	ma.Database = database
+	ma.tenant = tenant
+	provider = &ma
+	return
+}
+
+// Returns a copy of the provider that works on the database of the tenant
+// stored in the requestscope under TenantKey.
+func (ma DataProvider) ForRequest(rs requestscope.RequestScope) (provider *DataProvider, err *utils.Error) {
+
+	tenant := rs.Get(ma.tenantKey())
+	if tenant == nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Request does not have a tenant.",
+		}
+		return
+	}
+	return ma.WithTenant(fmt.Sprint(tenant))
+}
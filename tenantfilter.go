@@ -0,0 +1,158 @@
+package mongoutil
+
+import (
+	"encoding/json"
+	"github.com/rihtim/core/dataprovider"
+	"github.com/rihtim/core/messages"
+	"github.com/rihtim/core/methods"
+	"github.com/rihtim/core/requestscope"
+	"github.com/rihtim/core/utils"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Extras of the TenantFilter interceptor.
+type TenantFilterOptions struct {
+	// field of the documents that holds the tenant, defaults to "tenantId"
+	Field string
+	// key of the requestscope that holds the tenant, defaults to "tenantId"
+	ScopeKey string
+}
+
+func (o TenantFilterOptions) field() string {
+	if o.Field == "" {
+		return DefaultTenantKey
+	}
+	return o.Field
+}
+
+func (o TenantFilterOptions) scopeKey() string {
+	if o.ScopeKey == "" {
+		return DefaultTenantKey
+	}
+	return o.ScopeKey
+}
+
+// Restricts the request to the documents of the tenant in the requestscope.
+// Queries get the tenant added to their where filter or aggregation pipeline,
+// created documents get the tenant field set, and the requests on a single
+// document fail with 404 if the document belongs to another tenant. The include
+// parameter is rejected, since the related documents are fetched by the ids in
+// the document, which could refer to the documents of another tenant.
+// Must be added to all methods before the execution.
+// Example Usage:
+// core.Interceptors.Add(interceptors.AnyPath, methods.Any, interceptors.BEFORE_EXEC, mongoutil.TenantFilter, nil)
+func TenantFilter(rs requestscope.RequestScope, extras interface{}, req, res messages.Message, db dataprovider.Provider) (editedReq, editedRes messages.Message, editedRs requestscope.RequestScope, err *utils.Error) {
+
+	options, _ := extras.(TenantFilterOptions)
+	field := options.field()
+
+	tenant := rs.Get(options.scopeKey())
+	if tenant == nil {
+		err = &utils.Error{
+			Code:    http.StatusForbidden,
+			Message: "Request does not have a tenant.",
+		}
+		return
+	}
+
+	if err = checkNoIncludes(req); err != nil {
+		return
+	}
+
+	collection, id := splitResource(req.Res)
+	editedReq = req
+
+	// writes cannot move a document to another tenant
+	if value, hasField := req.Body[field]; hasField && !reflect.DeepEqual(value, tenant) {
+		err = &utils.Error{
+			Code:    http.StatusForbidden,
+			Message: "Input cannot contain another tenant's '" + field + "'.",
+		}
+		return
+	}
+
+	if id != "" {
+		document, getErr := db.Get(collection, id)
+		// a missing document fails the request itself, any other error must
+		// fail the check since the request would run without it
+		if getErr != nil {
+			if KindOf(getErr) != ErrNotFound {
+				err = getErr
+			}
+			return
+		}
+		if !reflect.DeepEqual(document[field], tenant) {
+			err = newError(ErrNotFound, "'"+collection+"' with id '"+id+"' not found.")
+		}
+		return
+	}
+
+	switch strings.ToLower(req.Command) {
+	case methods.Post:
+		body := make(map[string]interface{}, len(req.Body)+1)
+		for k, v := range req.Body {
+			body[k] = v
+		}
+		body[field] = tenant
+		editedReq.Body = body
+
+	case methods.Get:
+		editedReq.Parameters, err = withFilter(req.Parameters, map[string]interface{}{field: tenant})
+	}
+	return
+}
+
+// Returns 400 if the request includes the related documents, which cannot be
+// restricted by the interceptors isolating the documents of the requesters.
+func checkNoIncludes(req messages.Message) (err *utils.Error) {
+	if _, hasInclude := req.Parameters["include"]; hasInclude {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Include parameter cannot be used for '" + req.Res + "'.",
+		}
+	}
+	return
+}
+
+// Returns a copy of the parameters whose where filter and aggregation
+// pipeline also require the given filter to match.
+func withFilter(parameters map[string][]string, filter map[string]interface{}) (edited map[string][]string, err *utils.Error) {
+
+	edited = make(map[string][]string, len(parameters)+1)
+	for k, v := range parameters {
+		edited[k] = v
+	}
+
+	if _, hasAggregate := parameters["aggregate"]; hasAggregate {
+		pipeline, _, parseErr := extractJsonParameter(parameters, "aggregate")
+		if parseErr != nil {
+			err = parseErr
+			return
+		}
+		stages, _ := pipeline.([]interface{})
+		stages = append([]interface{}{map[string]interface{}{"$match": filter}}, stages...)
+		edited["aggregate"] = []string{mustMarshal(stages)}
+		return
+	}
+
+	where, hasWhere, parseErr := extractJsonParameter(parameters, "where")
+	if parseErr != nil {
+		err = parseErr
+		return
+	}
+
+	var combined interface{} = filter
+	if hasWhere && where != nil {
+		combined = map[string]interface{}{"$and": []interface{}{where, filter}}
+	}
+	edited["where"] = []string{mustMarshal(combined)}
+	return
+}
+
+// Marshals the values decoded from json, which can't fail.
+func mustMarshal(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
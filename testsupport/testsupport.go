@@ -0,0 +1,225 @@
+package testsupport
+
+import (
+	"fmt"
+	"github.com/rihtim/mongoutil"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// address of an already running server to use instead of starting one,
+// e.g. "127.0.0.1:27017" on CI where mongo runs as a service
+const AddressVariable = "MONGOUTIL_TEST_ADDRESS"
+
+// image that is run if docker is available
+var Image = "mongo:4.4"
+
+// time to wait for the started server to accept connections
+var StartTimeout = 30 * time.Second
+
+// A throwaway server started for the tests.
+type Server struct {
+	Address string
+	stop    func()
+}
+
+// Starts a throwaway server. The server given in MONGOUTIL_TEST_ADDRESS is
+// used if it is set, otherwise a container is run if docker is on the path
+// and a mongod process with a temporary data directory is exec'd if not.
+// The test is skipped if none of them is available.
+// Example Usage:
+//
+//	func TestMain(m *testing.M) {
+//	    server, err := testsupport.StartServer()
+//	    ...
+//	    defer server.Stop()
+//	}
+func StartServer() (server *Server, err error) {
+
+	if address := os.Getenv(AddressVariable); address != "" {
+		server = &Server{Address: address, stop: func() {}}
+		return
+	}
+
+	if _, lookErr := exec.LookPath("docker"); lookErr == nil {
+		server, err = startContainer()
+	} else if _, lookErr := exec.LookPath("mongod"); lookErr == nil {
+		server, err = startProcess()
+	} else {
+		err = fmt.Errorf("neither %s, docker nor mongod is available", AddressVariable)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	if err = waitUntilReady(server.Address); err != nil {
+		server.Stop()
+		server = nil
+	}
+	return
+}
+
+// Stops the server and removes its data. Servers given in MONGOUTIL_TEST_ADDRESS are left running.
+func (s *Server) Stop() {
+	s.stop()
+}
+
+// Returns a connected provider bound to a random database on the server.
+// The database is dropped and the provider is closed when the test finishes.
+// Example Usage:
+//
+//	provider := testsupport.NewProvider(t, server)
+//	_, err := provider.Create("users", map[string]interface{}{"name": "john"})
+func NewProvider(t testing.TB, server *Server) *mongoutil.DataProvider {
+
+	t.Helper()
+
+	provider := &mongoutil.DataProvider{
+		Addresses: []string{server.Address},
+		Database:  "test_" + bson.NewObjectId().Hex(),
+	}
+	if err := provider.Init(); err != nil {
+		t.Fatalf("Initializing provider failed: %s", err.Message)
+	}
+	if err := provider.Connect(); err != nil {
+		t.Fatalf("Connecting provider failed: %s", err.Message)
+	}
+
+	t.Cleanup(func() {
+		provider.Close(10 * time.Second)
+		if err := dropDatabase(server.Address, provider.Database); err != nil {
+			t.Logf("Dropping test database '%s' failed: %s", provider.Database, err.Error())
+		}
+	})
+	return provider
+}
+
+// Starts a server for the test alone and returns a provider connected to it.
+// Starting a server per test is slow, StartServer in TestMain should be
+// preferred when there are many tests.
+// Example Usage:
+// provider := testsupport.Start(t)
+func Start(t testing.TB) *mongoutil.DataProvider {
+
+	t.Helper()
+
+	server, err := StartServer()
+	if err != nil {
+		t.Skipf("Starting test server failed: %s", err.Error())
+	}
+	t.Cleanup(server.Stop)
+
+	return NewProvider(t, server)
+}
+
+func startContainer() (server *Server, err error) {
+
+	output, runErr := exec.Command("docker", "run", "--detach", "--rm", "--publish", "127.0.0.1::27017", Image).Output()
+	if runErr != nil {
+		err = fmt.Errorf("running container failed: %s", runErr.Error())
+		return
+	}
+	container := strings.TrimSpace(string(output))
+	stop := func() {
+		exec.Command("docker", "stop", container).Run()
+	}
+
+	output, portErr := exec.Command("docker", "port", container, "27017/tcp").Output()
+	if portErr != nil {
+		stop()
+		err = fmt.Errorf("reading container port failed: %s", portErr.Error())
+		return
+	}
+
+	// docker may list both the ipv4 and the ipv6 bindings
+	address := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+	server = &Server{Address: address, stop: stop}
+	return
+}
+
+func startProcess() (server *Server, err error) {
+
+	port, err := freePort()
+	if err != nil {
+		return
+	}
+
+	directory, dirErr := ioutil.TempDir("", "mongoutil-test")
+	if dirErr != nil {
+		err = fmt.Errorf("creating data directory failed: %s", dirErr.Error())
+		return
+	}
+
+	cmd := exec.Command("mongod", "--bind_ip", "127.0.0.1", "--port", strconv.Itoa(port), "--dbpath", directory)
+	if startErr := cmd.Start(); startErr != nil {
+		os.RemoveAll(directory)
+		err = fmt.Errorf("starting mongod failed: %s", startErr.Error())
+		return
+	}
+
+	server = &Server{
+		Address: "127.0.0.1:" + strconv.Itoa(port),
+		stop: func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+			os.RemoveAll(directory)
+		},
+	}
+	return
+}
+
+func freePort() (port int, err error) {
+
+	listener, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		err = fmt.Errorf("finding a free port failed: %s", listenErr.Error())
+		return
+	}
+	defer listener.Close()
+
+	port = listener.Addr().(*net.TCPAddr).Port
+	return
+}
+
+func waitUntilReady(address string) (err error) {
+
+	deadline := time.Now().Add(StartTimeout)
+	for {
+		session, dialErr := mgo.DialWithTimeout(address, time.Second)
+		if dialErr == nil {
+			err = session.Ping()
+			session.Close()
+			if err == nil {
+				return
+			}
+		} else {
+			err = dialErr
+		}
+
+		if time.Now().After(deadline) {
+			err = fmt.Errorf("server at %s is not ready: %s", address, err.Error())
+			return
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func dropDatabase(address, database string) error {
+
+	session, dialErr := mgo.DialWithTimeout(address, 5*time.Second)
+	if dialErr != nil {
+		return dialErr
+	}
+	defer session.Close()
+
+	return session.DB(database).DropDatabase()
+}
@@ -0,0 +1,64 @@
+package mongoutil
+
+import (
+	"time"
+)
+
+// Representation of the createdAt, updatedAt, deletedAt and lastAccessedAt
+// timestamps written by the provider.
+// Example Usage:
+// provider.TimestampMode = mongoutil.TimestampDate
+type TimestampMode int
+
+const (
+	// unix time in seconds as int64. this is the default
+	TimestampSeconds TimestampMode = iota
+	// unix time in milliseconds as int64
+	TimestampMillis
+	// RFC3339 string in UTC, e.g. "2006-01-02T15:04:05Z"
+	TimestampRFC3339
+	// time.Time, stored as an ISODate
+	TimestampDate
+)
+
+// Returns the time in the representation of the mode.
+func (m TimestampMode) value(t time.Time) interface{} {
+	switch m {
+	case TimestampMillis:
+		return t.UnixNano() / int64(time.Millisecond)
+	case TimestampRFC3339:
+		return t.UTC().Format(time.RFC3339)
+	case TimestampDate:
+		// mongo keeps millisecond precision, truncating keeps the
+		// returned value equal to the one read back
+		return t.Truncate(time.Millisecond)
+	}
+	return t.Unix()
+}
+
+// Returns the current time in the configured representation.
+func (ma DataProvider) now() interface{} {
+	return ma.TimestampMode.value(time.Now())
+}
+
+// Converts a timestamp in any of the representations, including the float64
+// seconds written by the previous versions, to time.Time so they can be compared.
+// Returns the zero time if the value is not a timestamp.
+func TimestampTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		t, _ := time.Parse(time.RFC3339, v)
+		return t
+	case nil:
+		return time.Time{}
+	}
+
+	// milliseconds are told apart from seconds by their magnitude
+	seconds := toFloat(value)
+	if seconds > 1e11 {
+		seconds /= 1000
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
@@ -0,0 +1,228 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"sync"
+	"time"
+)
+
+// capped collection that keeps the published messages of all the topics. the
+// oldest messages are dropped when it is full, so slow subscribers may miss them
+var (
+	TopicCollection     = "messages"
+	TopicCollectionSize = 16 * 1024 * 1024
+)
+
+type TopicMessage struct {
+	Topic       string                 `bson:"topic" json:"topic"`
+	Message     map[string]interface{} `bson:"message" json:"message"`
+	PublishedAt time.Time              `bson:"publishedAt" json:"publishedAt"`
+}
+
+type TopicSubscription struct {
+	Messages <-chan TopicMessage
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Publishes the message to the subscribers of the topic in all the instances
+// connected to the same database.
+// Example Usage:
+// provider.Publish("cache-invalidation", map[string]interface{}{"key": "users/42"})
+func (ma DataProvider) Publish(topic string, message map[string]interface{}) (err *utils.Error) {
+
+	op, err := ma.begin("publish", TopicCollection)
+	defer ma.track(op, &err)
+	defer ma.recoverPanic(op, &err)
+	if err != nil {
+		return
+	}
+
+	if err = ma.checkWritable(); err != nil {
+		return
+	}
+
+	sessionCopy := ma.copySession(TopicCollection, false, 1*time.Second, 1*time.Second)
+	defer sessionCopy.Close()
+	connection := sessionCopy.DB(ma.Database).C(TopicCollection)
+
+	if err = ma.ensureTopicCollection(connection); err != nil {
+		return
+	}
+
+	// the subscribers find the last message they read by its id
+	document := bson.M{ID: bson.NewObjectId(), "topic": topic, "message": message, "publishedAt": time.Now()}
+	publishErr := ma.retry(sessionCopy, ma.attempts(TopicCollection), func() error {
+		return connection.Insert(document)
+	})
+	if publishErr != nil {
+		err = driverError(publishErr, "Publishing to '"+topic+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason": publishErr.Error(),
+			"topic":  topic,
+		}).Error("Mongo Error: Publishing message failed.")
+	}
+	return
+}
+
+// Subscribes to the messages published to the topic after the subscription,
+// which are read with a tailable cursor. The subscription must be closed when
+// it is no longer needed. Named so since Subscribe delivers the changes of
+// the collections.
+// Example Usage:
+// sub, err := provider.SubscribeTopic("cache-invalidation")
+// defer sub.Close()
+// for message := range sub.Messages { ... }
+func (ma DataProvider) SubscribeTopic(topic string) (subscription *TopicSubscription, err *utils.Error) {
+
+	session := ma.copySession(TopicCollection, false, 5*time.Second, 0)
+	connection := session.DB(ma.Database).C(TopicCollection)
+
+	if err = ma.ensureTopicCollection(connection); err != nil {
+		session.Close()
+		return
+	}
+
+	// the messages published before the subscription are skipped
+	var last struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	if lastErr := connection.Find(bson.M{"topic": topic}).Sort("-$natural").Select(bson.M{ID: 1}).One(&last); lastErr != nil && lastErr != mgo.ErrNotFound {
+		err = driverError(lastErr, "Subscribing to '"+topic+"' failed.")
+		session.Close()
+
+		ma.logger().WithFields(LogFields{
+			"reason": lastErr.Error(),
+			"topic":  topic,
+		}).Error("Mongo Error: Finding last message failed.")
+		return
+	}
+
+	messages := make(chan TopicMessage, subscriptionBufferSize)
+	subscription = &TopicSubscription{
+		Messages: messages,
+		done:     make(chan struct{}),
+	}
+
+	go ma.tail(session, connection, topic, last.ID, messages, subscription.done)
+	return
+}
+
+// Stops the subscription and closes the Messages channel.
+func (s *TopicSubscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// Tails the messages of the topic in their insertion order. The cursor is kept
+// alive while it waits for new messages. If it dies, the messages are read again
+// from the beginning of the collection and the ones up to the last read message
+// are skipped, since the ids are generated by the publishers and are not in
+// order across the instances.
+func (ma DataProvider) tail(session *mgo.Session, connection *mgo.Collection, topic string, lastId bson.ObjectId, messages chan<- TopicMessage, done <-chan struct{}) {
+
+	defer session.Close()
+	defer close(messages)
+
+	var document struct {
+		ID           bson.ObjectId `bson:"_id"`
+		TopicMessage `bson:",inline"`
+	}
+
+	for {
+		// the last message is dropped from the capped collection only after the ones before it
+		skipping := false
+		if lastId != "" {
+			count, countErr := connection.FindId(lastId).Count()
+			skipping = countErr != nil || count > 0
+		}
+
+		// the cursor times out every second to check whether the subscription is closed
+		iter := connection.Find(bson.M{"topic": topic}).Sort("$natural").Tail(time.Second)
+		for {
+			for iter.Next(&document) {
+				if skipping {
+					skipping = document.ID != lastId
+					continue
+				}
+				lastId = document.ID
+				select {
+				case messages <- document.TopicMessage:
+				case <-done:
+					iter.Close()
+					return
+				}
+			}
+
+			select {
+			case <-done:
+				iter.Close()
+				return
+			default:
+			}
+
+			if !iter.Timeout() {
+				break
+			}
+		}
+
+		// the cursor dies if the collection is empty or the server closes it,
+		// so the query is run again
+		if iterErr := iter.Close(); iterErr != nil {
+			ma.logger().WithFields(LogFields{
+				"reason": iterErr.Error(),
+				"topic":  topic,
+			}).Error("Mongo Error: Tailing messages failed. Retrying.")
+
+			if isConnectionError(iterErr) {
+				ma.refreshSession(session)
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// Creates the capped collection of the messages if it doesn't exist.
+func (ma DataProvider) ensureTopicCollection(connection *mgo.Collection) (err *utils.Error) {
+
+	if ma.state != nil {
+		ma.state.mutex.RLock()
+		ensured := ma.state.ensuredIndexes[connection.FullName]
+		ma.state.mutex.RUnlock()
+		if ensured {
+			return
+		}
+	}
+
+	createErr := connection.Create(&mgo.CollectionInfo{Capped: true, MaxBytes: TopicCollectionSize})
+	if createErr != nil && !isNamespaceExists(createErr) && !strings.Contains(createErr.Error(), "already exists") {
+		err = driverError(createErr, "Creating collection '"+connection.Name+"' failed.")
+
+		ma.logger().WithFields(LogFields{
+			"reason":     createErr.Error(),
+			"collection": connection.Name,
+		}).Error("Mongo Error: Creating capped collection failed.")
+		return
+	}
+
+	if ma.state != nil {
+		ma.state.mutex.Lock()
+		if ma.state.ensuredIndexes == nil {
+			ma.state.ensuredIndexes = make(map[string]bool)
+		}
+		ma.state.ensuredIndexes[connection.FullName] = true
+		ma.state.mutex.Unlock()
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package mongoutil
+
+import (
+	"context"
+	"github.com/rihtim/core/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Returns a copy of the provider whose operations create their spans as
+// children of the span in the given context. Has no effect if Tracer is not set.
+// Example Usage:
+// item, err := provider.WithContext(ctx).Get("users", id)
+func (ma DataProvider) WithContext(ctx context.Context) *DataProvider {
+	ma.ctx = ctx
+	return &ma
+}
+
+func (ma DataProvider) startSpan(op *operation) {
+
+	if ma.Tracer == nil {
+		return
+	}
+
+	ctx := ma.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	_, op.span = ma.Tracer.Start(ctx, "mongo."+op.name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(op.start),
+	)
+}
+
+func (ma DataProvider) endSpan(op *operation, err *utils.Error) {
+
+	if op.span == nil {
+		return
+	}
+
+	// the shape is used instead of the filter itself so values never leave the service
+	op.span.SetAttributes(
+		attribute.String("db.system", "mongodb"),
+		attribute.String("db.name", ma.Database),
+		attribute.String("db.operation", op.name),
+		attribute.String("db.mongodb.collection", op.collection),
+	)
+	if op.shape != "" {
+		op.span.SetAttributes(attribute.String("db.statement", op.shape))
+	}
+
+	if err != nil {
+		op.span.RecordError(err)
+		op.span.SetStatus(codes.Error, err.Message)
+	}
+	op.span.End()
+}
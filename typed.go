@@ -0,0 +1,105 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"reflect"
+)
+
+// Creates a document from a struct using its bson tags. The response is the
+// same as Create's, the generated fields are not written back to the struct.
+// Example Usage:
+// response, err := provider.CreateFrom("users", User{Name: "john"})
+func (ma DataProvider) CreateFrom(collection string, item interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	data, err := toMap(item)
+	if err != nil {
+		return
+	}
+	return ma.Create(collection, data)
+}
+
+// Gets the document and decodes it into the given pointer using bson tags.
+// Example Usage:
+// var user User
+// err := provider.GetInto("users", id, &user)
+func (ma DataProvider) GetInto(collection string, id string, result interface{}) (err *utils.Error) {
+
+	response, err := ma.Get(collection, id)
+	if err != nil {
+		return
+	}
+	return fromMap(response, result)
+}
+
+// Queries the documents and decodes them into the given pointer to a slice.
+func (ma DataProvider) QueryInto(collection string, parameters map[string][]string, results interface{}) (err *utils.Error) {
+
+	response, err := ma.Query(collection, parameters)
+	if err != nil {
+		return
+	}
+
+	slice := reflect.ValueOf(results)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Query results must be decoded into a pointer to a slice.",
+		}
+		return
+	}
+	slice = slice.Elem()
+	slice.SetLen(0)
+
+	items, _ := response[List].([]map[string]interface{})
+	for _, item := range items {
+		element := reflect.New(slice.Type().Elem())
+		if err = fromMap(item, element.Interface()); err != nil {
+			return
+		}
+		slice.Set(reflect.Append(slice, element.Elem()))
+	}
+	return
+}
+
+// Updates the document with the fields of the struct. Fields tagged with
+// omitempty are not updated when they are empty.
+func (ma DataProvider) UpdateFrom(collection string, id string, item interface{}) (response map[string]interface{}, err *utils.Error) {
+
+	data, err := toMap(item)
+	if err != nil {
+		return
+	}
+	return ma.Update(collection, id, data)
+}
+
+func toMap(item interface{}) (data map[string]interface{}, err *utils.Error) {
+
+	raw, marshalErr := bson.Marshal(item)
+	if marshalErr == nil {
+		marshalErr = bson.Unmarshal(raw, &data)
+	}
+	if marshalErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Encoding item failed. Reason: " + marshalErr.Error(),
+		}
+	}
+	return
+}
+
+func fromMap(data interface{}, result interface{}) (err *utils.Error) {
+
+	raw, marshalErr := bson.Marshal(data)
+	if marshalErr == nil {
+		marshalErr = bson.Unmarshal(raw, result)
+	}
+	if marshalErr != nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Decoding item failed. Reason: " + marshalErr.Error(),
+		}
+	}
+	return
+}
@@ -0,0 +1,143 @@
+package mongoutil
+
+import (
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Documents and bytes read and written by the operations, collected
+// when CostAccounting is enabled.
+type Usage struct {
+	Operations       int64 `json:"operations"`
+	DocumentsRead    int64 `json:"documentsRead"`
+	DocumentsWritten int64 `json:"documentsWritten"`
+	BytesRead        int64 `json:"bytesRead"`
+	BytesWritten     int64 `json:"bytesWritten"`
+}
+
+func (u *Usage) add(other Usage) {
+	u.Operations += other.Operations
+	u.DocumentsRead += other.DocumentsRead
+	u.DocumentsWritten += other.DocumentsWritten
+	u.BytesRead += other.BytesRead
+	u.BytesWritten += other.BytesWritten
+}
+
+// caller of the operations that have no caller set with WithCaller
+const UnknownCaller = "unknown"
+
+// Returns a copy of the provider whose operations are accounted to the
+// given caller, e.g. a tenant or a product feature.
+// Example Usage:
+// provider.WithCaller("billing").Query("invoices", parameters)
+func (ma DataProvider) WithCaller(caller string) *DataProvider {
+	ma.caller = caller
+	return &ma
+}
+
+// Returns the usage since start or the last reset, by collection and caller.
+func (ma DataProvider) UsageReport() (report map[string]map[string]Usage) {
+
+	report = make(map[string]map[string]Usage)
+	if ma.state == nil {
+		return
+	}
+
+	ma.state.mutex.RLock()
+	defer ma.state.mutex.RUnlock()
+
+	for collection, callers := range ma.state.usage {
+		report[collection] = make(map[string]Usage, len(callers))
+		for caller, usage := range callers {
+			report[collection][caller] = *usage
+		}
+	}
+	return
+}
+
+func (ma DataProvider) ResetUsage() {
+
+	if ma.state == nil {
+		return
+	}
+
+	ma.state.mutex.Lock()
+	ma.state.usage = nil
+	ma.state.mutex.Unlock()
+}
+
+func (op *operation) countRead(documents ...map[string]interface{}) {
+	if !op.accounting {
+		return
+	}
+	for _, document := range documents {
+		op.usage.DocumentsRead++
+		op.usage.BytesRead += documentSize(document)
+	}
+}
+
+func (op *operation) countWritten(documents ...map[string]interface{}) {
+	if !op.accounting {
+		return
+	}
+	for _, document := range documents {
+		op.usage.DocumentsWritten++
+		op.usage.BytesWritten += documentSize(document)
+	}
+}
+
+func (op *operation) countFileRead(size int64) {
+	if op.accounting {
+		op.usage.DocumentsRead++
+		op.usage.BytesRead += size
+	}
+}
+
+func (op *operation) countFileWritten(size int64) {
+	if op.accounting {
+		op.usage.DocumentsWritten++
+		op.usage.BytesWritten += size
+	}
+}
+
+func (ma DataProvider) recordUsage(op *operation) {
+
+	if !op.accounting || ma.state == nil {
+		return
+	}
+
+	// operations of a tenant are accounted to it unless a caller is given
+	caller := ma.caller
+	if caller == "" {
+		caller = ma.tenant
+	}
+	if caller == "" {
+		caller = UnknownCaller
+	}
+	op.usage.Operations = 1
+
+	ma.state.mutex.Lock()
+	defer ma.state.mutex.Unlock()
+
+	if ma.state.usage == nil {
+		ma.state.usage = make(map[string]map[string]*Usage)
+	}
+	if ma.state.usage[op.collection] == nil {
+		ma.state.usage[op.collection] = make(map[string]*Usage)
+	}
+	if ma.state.usage[op.collection][caller] == nil {
+		ma.state.usage[op.collection][caller] = &Usage{}
+	}
+	ma.state.usage[op.collection][caller].add(op.usage)
+}
+
+// Returns the size of the document as stored in bson.
+func documentSize(document map[string]interface{}) int64 {
+	if document == nil {
+		return 0
+	}
+	raw, marshalErr := bson.Marshal(document)
+	if marshalErr != nil {
+		return 0
+	}
+	return int64(len(raw))
+}
@@ -0,0 +1,47 @@
+package mongoutil
+
+import (
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strconv"
+)
+
+func (ma DataProvider) isVersioned(collection string) bool {
+	return ma.Versioning[collection]
+}
+
+// Returns the version sent by the caller. Updates of versioned
+// collections must contain the version they are based on.
+func expectedVersion(data map[string]interface{}) (version int64, err *utils.Error) {
+
+	value, hasVersion := data[Version]
+	if !hasVersion {
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Update must contain the '" + Version + "' field.",
+		}
+		return
+	}
+
+	switch value.(type) {
+	case float64, int, int32, int64:
+	default:
+		err = &utils.Error{
+			Code:    http.StatusBadRequest,
+			Message: "The key '" + Version + "' must be an integer.",
+		}
+		return
+	}
+	version = int64(toFloat(value))
+	return
+}
+
+func versionConflict(collection, id string, version int64) *utils.Error {
+	return newError(ErrConflict, "'"+collection+"' with id '"+id+"' is not at version "+strconv.FormatInt(version, 10)+".")
+}
+
+// Returns the filter that matches the document only if it is still at the version.
+func versionFilter(id interface{}, version int64) bson.M {
+	return bson.M{ID: id, Version: version}
+}
@@ -0,0 +1,230 @@
+package mongoutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rihtim/core/utils"
+	"gopkg.in/mgo.v2/bson"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// collection that keeps the events that could not be delivered to the webhooks
+var WebhookDeadLetterCollection = "webhookDeadLetters"
+
+// An endpoint that the change events of the collections are POSTed to as json.
+type Webhook struct {
+	URL         string
+	Collections []string
+	// sent with every request, e.g. for authorization
+	Headers map[string]string
+}
+
+// Forwards the change events of the collections to the webhooks. Since the
+// events come from Subscribe, only the changes made through the provider are
+// forwarded. Events are delivered in order per webhook and collection, and
+// retried with exponential backoff. The events failing all the attempts are
+// written to WebhookDeadLetterCollection.
+// Example Usage:
+//
+//	forwarder := provider.NewWebhookForwarder([]mongoutil.Webhook{
+//	    {URL: "https://crm.example.com/hooks/users", Collections: []string{"users"}},
+//	})
+//	forwarder.Start()
+//	defer forwarder.Stop()
+type WebhookForwarder struct {
+	Webhooks []Webhook
+	// defaults to 5
+	Attempts int
+	// wait before the first retry, doubled for each retry. defaults to 1 second
+	Backoff time.Duration
+	Client  *http.Client
+
+	provider      DataProvider
+	subscriptions []*Subscription
+	wg            sync.WaitGroup
+}
+
+func (ma DataProvider) NewWebhookForwarder(webhooks []Webhook) *WebhookForwarder {
+	return &WebhookForwarder{
+		Webhooks: webhooks,
+		Attempts: 5,
+		Backoff:  time.Second,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		provider: ma,
+	}
+}
+
+// Subscribes to the collections of the webhooks and starts forwarding their events.
+func (f *WebhookForwarder) Start() (err *utils.Error) {
+
+	if f.provider.state == nil {
+		err = &utils.Error{
+			Code:    http.StatusInternalServerError,
+			Message: "Provider must be initialized before forwarding changes.",
+		}
+		return
+	}
+
+	for _, webhook := range f.Webhooks {
+		for _, collection := range webhook.Collections {
+			subscription := f.provider.Subscribe(collection)
+			f.subscriptions = append(f.subscriptions, subscription)
+
+			// the events are moved to an unbounded queue as soon as they arrive, since
+			// the subscription drops the events while the retries block the delivery
+			queue := newEventQueue()
+			f.wg.Add(2)
+			go func(events <-chan ChangeEvent) {
+				defer f.wg.Done()
+				for event := range events {
+					queue.push(event)
+				}
+				queue.close()
+			}(subscription.Events)
+			go func(webhook Webhook) {
+				defer f.wg.Done()
+				for event, ok := queue.pop(); ok; event, ok = queue.pop() {
+					f.deliver(webhook, event)
+				}
+			}(webhook)
+		}
+	}
+	return
+}
+
+// Stops forwarding and waits for the received events to be delivered.
+func (f *WebhookForwarder) Stop() {
+	for _, subscription := range f.subscriptions {
+		subscription.Unsubscribe()
+	}
+	f.subscriptions = nil
+	f.wg.Wait()
+}
+
+func (f *WebhookForwarder) deliver(webhook Webhook, event ChangeEvent) {
+
+	// the documents are shared with the other subscribers
+	event.Before = f.provider.stringDocument(event.Collection, event.Before)
+	event.After = f.provider.stringDocument(event.Collection, event.After)
+
+	body, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		f.deadLetter(webhook, event, marshalErr.Error())
+		return
+	}
+
+	backoff := f.Backoff
+	var reason string
+	for attempt := 1; attempt <= f.Attempts; attempt++ {
+		if reason = f.post(webhook, body); reason == "" {
+			return
+		}
+
+		f.provider.logger().WithFields(LogFields{
+			"reason":     reason,
+			"url":        webhook.URL,
+			"collection": event.Collection,
+			"attempt":    attempt,
+		}).Error("Webhook Error: Delivering change event failed.")
+
+		if attempt < f.Attempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	f.deadLetter(webhook, event, reason)
+}
+
+// Posts the event and returns the reason of the failure, or empty string if it is delivered.
+func (f *WebhookForwarder) post(webhook Webhook, body []byte) (reason string) {
+
+	request, requestErr := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if requestErr != nil {
+		return requestErr.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range webhook.Headers {
+		request.Header.Set(k, v)
+	}
+
+	response, postErr := f.Client.Do(request)
+	if postErr != nil {
+		return postErr.Error()
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return "webhook responded with status " + strconv.Itoa(response.StatusCode)
+	}
+	return ""
+}
+
+func (f *WebhookForwarder) deadLetter(webhook Webhook, event ChangeEvent, reason string) {
+
+	session := f.provider.copySession(WebhookDeadLetterCollection, false, 1*time.Second, 1*time.Second)
+	defer session.Close()
+
+	insertErr := session.DB(f.provider.Database).C(WebhookDeadLetterCollection).Insert(bson.M{
+		"url":      webhook.URL,
+		"event":    event,
+		"reason":   reason,
+		"failedAt": time.Now(),
+	})
+	if insertErr != nil {
+		f.provider.logger().WithFields(LogFields{
+			"reason":     insertErr.Error(),
+			"url":        webhook.URL,
+			"collection": event.Collection,
+			"id":         event.ID,
+		}).Error("Webhook Error: Writing dead letter failed. Change event is lost.")
+	}
+}
+
+// FIFO queue of the change events without a limit, whose pop blocks until an
+// event is pushed or the queue is closed.
+type eventQueue struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	events []ChangeEvent
+	closed bool
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{}
+	q.cond = sync.NewCond(&q.mutex)
+	return q
+}
+
+func (q *eventQueue) push(event ChangeEvent) {
+	q.mutex.Lock()
+	q.events = append(q.events, event)
+	q.mutex.Unlock()
+	q.cond.Signal()
+}
+
+func (q *eventQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.cond.Broadcast()
+}
+
+// Returns false once the queue is closed and all its events are popped.
+func (q *eventQueue) pop() (event ChangeEvent, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.events) == 0 {
+		return
+	}
+	event = q.events[0]
+	q.events[0] = ChangeEvent{}
+	q.events = q.events[1:]
+	return event, true
+}